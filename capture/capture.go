@@ -0,0 +1,219 @@
+// Package capture reads and writes raw telemetry capture files.
+//
+// A capture file is append-only and made of a magic, a header and records:
+//
+//	file   = magic header record*
+//	magic  = "CMTC" version(uint16)
+//	header = length(uint32) JSON(Header)
+//	record = length(uint32) kind(uint8) time(int64) addr format payload
+//	addr   = length(uint8) bytes  // source address "ip:port"
+//	format = length(uint8) bytes  // detected codemasters.Format, empty if unknown
+//
+// All integers are little-endian, length of a record excludes its length field
+// and time is the receive time in unix nanoseconds.
+// Kind 'P' records carry a raw datagram as payload, kind 'M' records carry
+// a JSON Header replacing the current one (game, car or stage changed).
+// A truncated last record, e.g. after a crash, is ignored by the Reader,
+// records and headers larger than MaxRecordSize are rejected as corrupt.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	Magic   = "CMTC"
+	Version = 1
+)
+
+// MaxRecordSize bounds the length of a record or header, as pcap's snapshot length.
+const MaxRecordSize = 1 << 24
+
+const (
+	KindPacket = 'P'
+	KindMeta   = 'M'
+)
+
+type Header struct {
+	Software string    `json:"software"`
+	Created  time.Time `json:"created"`
+	Game     string    `json:"game,omitempty"`
+	Car      string    `json:"car,omitempty"`
+	Stage    string    `json:"stage,omitempty"`
+}
+
+type Packet struct {
+	Time   time.Time
+	Addr   string
+	Format string
+	Data   []byte
+}
+
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewWriter writes the magic and h to w.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriterSize(w, 64*1024)}
+	cw.buf = append(cw.buf[:0], Magic...)
+	cw.buf = binary.LittleEndian.AppendUint16(cw.buf, Version)
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	cw.buf = binary.LittleEndian.AppendUint32(cw.buf, uint32(len(b)))
+	cw.buf = append(cw.buf, b...)
+	if _, err := cw.w.Write(cw.buf); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *Writer) WritePacket(p Packet) error {
+	return w.write(KindPacket, p.Time, p.Addr, p.Format, p.Data)
+}
+
+// WriteMeta records a header change.
+func (w *Writer) WriteMeta(t time.Time, h Header) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return w.write(KindMeta, t, "", "", b)
+}
+
+func (w *Writer) write(kind byte, t time.Time, addr, format string, payload []byte) error {
+	if len(addr) > 255 || len(format) > 255 {
+		return fmt.Errorf("capture: address or format too long")
+	}
+	n := 1 + 8 + 1 + len(addr) + 1 + len(format) + len(payload)
+	if n > MaxRecordSize {
+		return fmt.Errorf("capture: record too large: %d", n)
+	}
+	w.buf = binary.LittleEndian.AppendUint32(w.buf[:0], uint32(n))
+	w.buf = append(w.buf, kind)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(t.UnixNano()))
+	w.buf = append(w.buf, byte(len(addr)))
+	w.buf = append(w.buf, addr...)
+	w.buf = append(w.buf, byte(len(format)))
+	w.buf = append(w.buf, format...)
+	w.buf = append(w.buf, payload...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+	buf    []byte
+}
+
+// NewReader reads the magic and the header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+	var head [10]byte
+	if _, err := io.ReadFull(cr.r, head[:]); err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	if string(head[:4]) != Magic {
+		return nil, fmt.Errorf("capture: not a capture file")
+	}
+	if v := binary.LittleEndian.Uint16(head[4:6]); v != Version {
+		return nil, fmt.Errorf("capture: unsupported version %d", v)
+	}
+	n := binary.LittleEndian.Uint32(head[6:10])
+	if n > MaxRecordSize {
+		return nil, fmt.Errorf("capture: invalid header size: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	if err := json.Unmarshal(b, &cr.header); err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	return cr, nil
+}
+
+// Header returns the current header, updated by meta records read so far.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next packet, its Data is valid until the next call.
+// It returns io.EOF at the end of the file.
+func (r *Reader) Next() (Packet, error) {
+	for {
+		kind, p, err := r.next()
+		if err != nil {
+			return Packet{}, err
+		}
+		switch kind {
+		case KindPacket:
+			return p, nil
+		case KindMeta:
+			var h Header
+			if err := json.Unmarshal(p.Data, &h); err != nil {
+				return Packet{}, fmt.Errorf("capture: %w", err)
+			}
+			r.header = h
+		}
+	}
+}
+
+func (r *Reader) next() (byte, Packet, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return 0, Packet{}, err
+	}
+	n := int(binary.LittleEndian.Uint32(head[:]))
+	if n > MaxRecordSize {
+		return 0, Packet{}, fmt.Errorf("capture: invalid record size: %d", n)
+	}
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF // truncated last record
+		}
+		return 0, Packet{}, err
+	}
+	if n < 11 {
+		return 0, Packet{}, fmt.Errorf("capture: invalid record size: %d", n)
+	}
+	kind := b[0]
+	p := Packet{Time: time.Unix(0, int64(binary.LittleEndian.Uint64(b[1:9])))}
+	b = b[9:]
+	if l := int(b[0]); len(b) >= 1+l+1 {
+		p.Addr = string(b[1 : 1+l])
+		b = b[1+l:]
+	} else {
+		return 0, Packet{}, fmt.Errorf("capture: invalid record")
+	}
+	if l := int(b[0]); len(b) >= 1+l {
+		p.Format = string(b[1 : 1+l])
+		b = b[1+l:]
+	} else {
+		return 0, Packet{}, fmt.Errorf("capture: invalid record")
+	}
+	p.Data = b
+	return kind, p, nil
+}
@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func testCapture(t *testing.T, packets ...Packet) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Header{Software: "test", Game: "dirt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteMeta(time.Unix(2, 0), Header{Software: "test", Game: "dirt", Stage: "stage"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadWrite(t *testing.T) {
+	in := Packet{Time: time.Unix(1, 500), Addr: "192.0.2.1:20777", Format: "dirt", Data: []byte{1, 2, 3}}
+	r, err := NewReader(bytes.NewReader(testCapture(t, in, in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Header(); h.Software != "test" || h.Game != "dirt" {
+		t.Errorf("header: %+v", h)
+	}
+	for i := 0; i < 2; i++ {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Time.Equal(in.Time) || p.Addr != in.Addr || p.Format != in.Format || !bytes.Equal(p.Data, in.Data) {
+			t.Errorf("packet %d: %+v, want %+v", i, p, in)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("end: %v, want EOF", err)
+	}
+	if h := r.Header(); h.Stage != "stage" {
+		t.Errorf("meta: %+v", h)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	in := Packet{Time: time.Unix(1, 0), Addr: "192.0.2.1:20777", Format: "dirt", Data: []byte{1, 2, 3}}
+	b := testCapture(t, in)
+	r, err := NewReader(bytes.NewReader(b[:len(b)-20])) // inside the meta record
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("truncated record: %v, want EOF", err)
+	}
+}
+
+func TestReadOversized(t *testing.T) {
+	b := testCapture(t)
+	b = binary.LittleEndian.AppendUint32(b, MaxRecordSize+1)
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("oversized record: %v, want an error", err)
+	}
+
+	head := append([]byte(Magic), 1, 0)
+	head = binary.LittleEndian.AppendUint32(head, MaxRecordSize+1)
+	if _, err := NewReader(bytes.NewReader(head)); err == nil {
+		t.Error("oversized header: no error")
+	}
+}
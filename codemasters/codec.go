@@ -0,0 +1,88 @@
+package codemasters
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// codec reads or writes little-endian values at increasing offsets,
+// so a packet layout is written once for both directions.
+// Values past the end of b are skipped (left untouched when reading).
+type codec struct {
+	b     []byte
+	off   int
+	write bool
+}
+
+func (c *codec) next(n int) []byte {
+	if c.off+n > len(c.b) {
+		c.off += n
+		return nil
+	}
+	v := c.b[c.off : c.off+n]
+	c.off += n
+	return v
+}
+
+func (c *codec) u8(v *uint8) {
+	b := c.next(1)
+	if b == nil {
+		return
+	}
+	if c.write {
+		b[0] = *v
+		return
+	}
+	*v = b[0]
+}
+
+func (c *codec) bool(v *bool) {
+	b := c.next(1)
+	if b == nil {
+		return
+	}
+	if c.write {
+		b[0] = 0
+		if *v {
+			b[0] = 1
+		}
+		return
+	}
+	*v = b[0] != 0
+}
+
+func (c *codec) u64(v *uint64) {
+	b := c.next(8)
+	if b == nil {
+		return
+	}
+	if c.write {
+		binary.LittleEndian.PutUint64(b, *v)
+		return
+	}
+	*v = binary.LittleEndian.Uint64(b)
+}
+
+func (c *codec) f32(v *float32) {
+	b := c.next(4)
+	if b == nil {
+		return
+	}
+	if c.write {
+		binary.LittleEndian.PutUint32(b, math.Float32bits(*v))
+		return
+	}
+	*v = math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+func (c *codec) f64(v *float64) {
+	b := c.next(8)
+	if b == nil {
+		return
+	}
+	if c.write {
+		binary.LittleEndian.PutUint64(b, math.Float64bits(*v))
+		return
+	}
+	*v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
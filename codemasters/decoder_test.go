@@ -0,0 +1,185 @@
+package codemasters
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func dirtSeriesPacket(tb testing.TB, level int) []byte {
+	p := &PacketDirtSeries{
+		Time: 12.5, LapTime: 12.5, LapDistance: 230, VehicleSpeed: 25,
+		VehicleThrottle: 0.8, VehicleSteering: -0.2, VehicleGear: 3,
+		EngineRate: 650, TrackSize: 9000, MaxRpm: 800, IdleRpm: 90, MaxGears: 6,
+		VehicleRightDirectionX: 1, VehicleForwardDirectionZ: 1,
+		ExtraData: level,
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+func easportsWRCPacket(tb testing.TB) []byte {
+	p := &PacketEASportsWRC{
+		PacketUid: 1, GameTotalTime: 12.5, GameFrameCount: 750,
+		VehicleGearIndex: 3, VehicleGearIndexNeutral: 0, VehicleGearIndexReverse: 7, VehicleGearMaximum: 6,
+		VehicleSpeed: 25, VehicleEngineRpmMax: 8000, VehicleEngineRpmIdle: 900, VehicleEngineRpmCurrent: 6500,
+		VehicleThrottle: 0.8, VehicleSteering: -0.2,
+		StageCurrentTime: 12.5, StageCurrentDistance: 230, StageLength: 9000,
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+func easportsWRCSessionPacket(tb testing.TB) []byte {
+	return append([]byte("sesu"), easportsWRCPacket(tb)...)
+}
+
+// f1SeriesPacket returns an F1 24 car telemetry packet of the player car 0.
+func f1SeriesPacket() []byte {
+	b := make([]byte, PacketF1HeaderLength+F1NumCars*F1CarTelemetryDataLength)
+	binary.LittleEndian.PutUint16(b[0:2], 2024)
+	b[2] = 24
+	b[6] = F1PacketCarTelemetry
+	body := b[PacketF1HeaderLength:]
+	binary.LittleEndian.PutUint16(body[0:2], 180)
+	binary.LittleEndian.PutUint32(body[2:6], math.Float32bits(0.8))
+	body[15] = 5
+	binary.LittleEndian.PutUint16(body[16:18], 10500)
+	return b
+}
+
+const testStructure = `{
+	"id": "test",
+	"packets": [{
+		"id": "session_update",
+		"4cc": "test",
+		"header": {"channels": ["packet_4cc", "packet_uid"]},
+		"channels": ["vehicle_steering", "vehicle_clutch", "vehicle_handbrake", "stage_current_time", "stage_current_distance", "stage_length"]
+	}]
+}`
+
+func easportsWRCCustomPacket(tb testing.TB) []byte {
+	s, err := LoadEASportsWRCStructure(strings.NewReader(testStructure))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := RegisterEASportsWRCStructure(s); err != nil {
+		tb.Fatal(err)
+	}
+	b := make([]byte, 4+8+4*4+8*2)
+	copy(b, "test")
+	binary.LittleEndian.PutUint64(b[4:12], 1)
+	binary.LittleEndian.PutUint32(b[12:16], math.Float32bits(-0.2))
+	binary.LittleEndian.PutUint32(b[24:28], math.Float32bits(12.5))
+	binary.LittleEndian.PutUint64(b[28:36], math.Float64bits(230))
+	binary.LittleEndian.PutUint64(b[36:44], math.Float64bits(9000))
+	return b
+}
+
+type decoderCase struct {
+	name   string
+	format Format
+	packet func(tb testing.TB) []byte
+}
+
+var decoderCases = []decoderCase{
+	{"DirtSeries0", FormatDirtSeries, func(tb testing.TB) []byte { return dirtSeriesPacket(tb, 0) }},
+	{"DirtSeries1", FormatDirtSeries, func(tb testing.TB) []byte { return dirtSeriesPacket(tb, 1) }},
+	{"DirtSeries2", FormatDirtSeries, func(tb testing.TB) []byte { return dirtSeriesPacket(tb, 2) }},
+	{"DirtSeries3", FormatDirtSeries, func(tb testing.TB) []byte { return dirtSeriesPacket(tb, 3) }},
+	{"EASportsWRC", FormatEASportsWRC, easportsWRCPacket},
+	{"EASportsWRCSession", FormatEASportsWRCSession, easportsWRCSessionPacket},
+	{"EASportsWRCCustom", Format("easportswrc:test"), easportsWRCCustomPacket},
+	{"F1Series", FormatF1Series, func(testing.TB) []byte { return f1SeriesPacket() }},
+}
+
+func TestDecoderDetect(t *testing.T) {
+	for _, c := range decoderCases {
+		t.Run(c.name, func(t *testing.T) {
+			var f Frame
+			format, err := NewDecoder(-1).DecodeFrame(c.packet(t), &f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != c.format {
+				t.Errorf("format = %q, want %q", format, c.format)
+			}
+		})
+	}
+}
+
+// Session packets without telemetry keep the latest update in a Decoder.
+func TestDecoderSession(t *testing.T) {
+	d := NewDecoder(-1)
+	pkt, _, err := d.Decode(easportsWRCSessionPacket(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Format() != FormatEASportsWRCSession {
+		t.Errorf("packet format %q, want %q", pkt.Format(), FormatEASportsWRCSession)
+	}
+	pause := append([]byte("sesp"), make([]byte, 8)...)
+	pkt, _, err = d.Decode(pause)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f Frame
+	pkt.Frame(&f)
+	if pkt.(Session).Event() != EventSessionPause || f.Format != FormatEASportsWRC || f.Speed != 25 {
+		t.Errorf("pause: event %q format %q speed %v", pkt.(Session).Event(), f.Format, f.Speed)
+	}
+
+	pkt, _, err = Decode(pause)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.Speed() != 0 {
+		t.Errorf("stateless pause: speed %v, want 0", pkt.Speed())
+	}
+}
+
+func TestDecoderAllocs(t *testing.T) {
+	for _, c := range decoderCases {
+		t.Run(c.name, func(t *testing.T) {
+			b := c.packet(t)
+			d := NewDecoder(-1)
+			var f Frame
+			if _, err := d.DecodeFrame(b, &f); err != nil { // first packet of the format
+				t.Fatal(err)
+			}
+			allocs := testing.AllocsPerRun(100, func() {
+				d.DecodeFrame(b, &f)
+			})
+			if allocs != 0 {
+				t.Errorf("DecodeFrame allocates %v times per packet", allocs)
+			}
+		})
+	}
+}
+
+func benchmarkDecoder(b *testing.B, c decoderCase) {
+	pkt := c.packet(b)
+	d := NewDecoder(-1)
+	var f Frame
+	b.ReportAllocs()
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.DecodeFrame(pkt, &f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderDirtSeries(b *testing.B)         { benchmarkDecoder(b, decoderCases[3]) }
+func BenchmarkDecoderEASportsWRC(b *testing.B)        { benchmarkDecoder(b, decoderCases[4]) }
+func BenchmarkDecoderEASportsWRCSession(b *testing.B) { benchmarkDecoder(b, decoderCases[5]) }
+func BenchmarkDecoderEASportsWRCCustom(b *testing.B)  { benchmarkDecoder(b, decoderCases[6]) }
+func BenchmarkDecoderF1Series(b *testing.B)           { benchmarkDecoder(b, decoderCases[7]) }
@@ -6,8 +6,75 @@ import (
 	"math"
 )
 
+// PacketDirtSeriesLength was the only accepted packet size.
+//
+// Deprecated: packets are decoded at every extradata level, use the sizes below.
 const PacketDirtSeriesLength = 263
 
+// packet sizes for each extradata setting, shorter packets carry a prefix of PacketDirtSeries
+const (
+	PacketDirtSeriesExtraData0Length = 64  // Time ... VehicleForwardDirectionY
+	PacketDirtSeriesExtraData1Length = 152 // ... EngineRate
+	PacketDirtSeriesExtraData2Length = 256 // ... MaxRpm
+	PacketDirtSeriesExtraData3Length = 264 // ... MaxGears (DiRT Rally 2.0)
+	PacketDirtSeriesFullLength       = 280 // ... VehicleFIAFlags (F1 legacy mode)
+)
+
+func init() {
+	Register(FormatSpec{
+		Format: FormatDirtSeries,
+		Detect: detectDirtSeries,
+		New:    func() Packet { return &PacketDirtSeries{} },
+	})
+}
+
+// dirtSeriesNonCanonical lowers the score of packets longer than the size of
+// their extradata level, e.g. from versions appending fields.
+const dirtSeriesNonCanonical = 5
+
+// dirtSeriesUnitTolerance bounds the squared length error of the direction vectors.
+const dirtSeriesUnitTolerance = 0.05
+
+// detectDirtSeries checks the packet size, the direction vectors and, when
+// present, the driver inputs. All-zero packets have no direction and fail.
+func detectDirtSeries(b []byte) int {
+	level := dirtSeriesLevel(len(b))
+	if level < 0 {
+		return 0
+	}
+	penalty := 0
+	if len(b) != dirtSeriesLength(level) && len(b) != PacketDirtSeriesFullLength {
+		penalty = dirtSeriesNonCanonical
+	}
+	f := func(i int) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[4*i : 4*(i+1)]))
+	}
+	if !plausible(f(0), 0, math.MaxFloat32) { // Time
+		return 10
+	}
+	right := f(11)*f(11) + f(12)*f(12) + f(13)*f(13)
+	if !plausible(right, 1-dirtSeriesUnitTolerance, 1+dirtSeriesUnitTolerance) {
+		return 10
+	}
+	if level == 0 { // forward direction without Z
+		if !plausible(f(14)*f(14)+f(15)*f(15), 0, 1+dirtSeriesUnitTolerance) {
+			return 10
+		}
+		return 60 - penalty
+	}
+	forward := f(14)*f(14) + f(15)*f(15) + f(16)*f(16)
+	dot := f(11)*f(14) + f(12)*f(15) + f(13)*f(16)
+	if !plausible(forward, 1-dirtSeriesUnitTolerance, 1+dirtSeriesUnitTolerance) ||
+		!plausible(dot, -dirtSeriesUnitTolerance, dirtSeriesUnitTolerance) {
+		return 10
+	}
+	if plausible(f(29), 0, 1) && plausible(f(30), -1, 1) && plausible(f(31), 0, 1) &&
+		plausible(f(32), 0, 1) && plausible(f(33), -1, 10) { // throttle, steering, brake, clutch, gear
+		return 90 - penalty
+	}
+	return 10
+}
+
 type PacketDirtSeries struct {
 	Time                     float32
 	LapTime                  float32
@@ -71,85 +138,200 @@ type PacketDirtSeries struct {
 	TrackSize   float32 // track size meters
 	LastLapTime float32 // last lap time
 	MaxRpm      float32 // cars max RPM, at which point the rev limiter will kick in
-	//Idle_rpm               float32    // cars idle RPM
-	//Max_gears              float32    // maximum number of gears
-	//SessionType            float32    // 0 = unknown, 1 = practice, 2 = qualifying, 3 = race
-	//DrsAllowed             float32    // 0 = not allowed, 1 = allowed, -1 = invalid / unknown
-	//Track_number           float32    // -1 for unknown, 0-21 for tracks
-	//VehicleFIAFlags        float32    // -1 = invalid/unknown, 0 = none, 1 = green, 2 = blue, 3 = yellow, 4 = red
+	//############################################################# extradata=3 start
+	IdleRpm         float32 // cars idle RPM
+	MaxGears        float32 // maximum number of gears
+	SessionType     float32 // 0 = unknown, 1 = practice, 2 = qualifying, 3 = race
+	DrsAllowed      float32 // 0 = not allowed, 1 = allowed, -1 = invalid / unknown
+	TrackNumber     float32 // -1 for unknown, 0-21 for tracks
+	VehicleFIAFlags float32 // -1 = invalid/unknown, 0 = none, 1 = green, 2 = blue, 3 = yellow, 4 = red
+	//############################################################# extradata=3 end
+
+	ExtraData int // detected extradata level (0-3), not part of the packet
+	size      int // received packet size, kept for MarshalBinary
 }
 
 func (p *PacketDirtSeries) UnmarshalBinary(b []byte) error {
-	if len(b) < PacketDirtSeriesLength {
-		return fmt.Errorf("invalid packet size: %d", len(b))
-	}
-	p.Time = math.Float32frombits(binary.LittleEndian.Uint32(b[:4]))
-	p.LapTime = math.Float32frombits(binary.LittleEndian.Uint32(b[4:8]))
-	p.LapDistance = math.Float32frombits(binary.LittleEndian.Uint32(b[8:12]))
-	p.TotalDistance = math.Float32frombits(binary.LittleEndian.Uint32(b[12:16]))
-	p.VehiclePosX = math.Float32frombits(binary.LittleEndian.Uint32(b[16:20]))
-	p.VehiclePosY = math.Float32frombits(binary.LittleEndian.Uint32(b[20:24]))
-	p.VehiclePosZ = math.Float32frombits(binary.LittleEndian.Uint32(b[24:28]))
-	p.VehicleSpeed = math.Float32frombits(binary.LittleEndian.Uint32(b[28:32]))
-	p.VehicleVelX = math.Float32frombits(binary.LittleEndian.Uint32(b[32:36]))
-	p.VehicleVelY = math.Float32frombits(binary.LittleEndian.Uint32(b[36:40]))
-	p.VehicleVelZ = math.Float32frombits(binary.LittleEndian.Uint32(b[40:44]))
-	p.VehicleRightDirectionX = math.Float32frombits(binary.LittleEndian.Uint32(b[44:48]))
-	p.VehicleRightDirectionY = math.Float32frombits(binary.LittleEndian.Uint32(b[48:52]))
-	p.VehicleRightDirectionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[52:56]))
-	p.VehicleForwardDirectionX = math.Float32frombits(binary.LittleEndian.Uint32(b[56:60]))
-	p.VehicleForwardDirectionY = math.Float32frombits(binary.LittleEndian.Uint32(b[60:64]))
-	p.VehicleForwardDirectionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[64:68]))
-	p.SuspPosBl = math.Float32frombits(binary.LittleEndian.Uint32(b[68:72]))
-	p.SuspPosBr = math.Float32frombits(binary.LittleEndian.Uint32(b[72:76]))
-	p.SuspPosFl = math.Float32frombits(binary.LittleEndian.Uint32(b[76:80]))
-	p.SuspPosFr = math.Float32frombits(binary.LittleEndian.Uint32(b[80:84]))
-	p.SuspVelBl = math.Float32frombits(binary.LittleEndian.Uint32(b[84:88]))
-	p.SuspVelBr = math.Float32frombits(binary.LittleEndian.Uint32(b[88:92]))
-	p.SuspVelFl = math.Float32frombits(binary.LittleEndian.Uint32(b[92:96]))
-	p.SuspVelFr = math.Float32frombits(binary.LittleEndian.Uint32(b[96:100]))
-	p.WheelSpeedBl = math.Float32frombits(binary.LittleEndian.Uint32(b[100:104]))
-	p.WheelSpeedBr = math.Float32frombits(binary.LittleEndian.Uint32(b[104:108]))
-	p.WheelSpeedFl = math.Float32frombits(binary.LittleEndian.Uint32(b[108:112]))
-	p.WheelSpeedFr = math.Float32frombits(binary.LittleEndian.Uint32(b[112:116]))
-	p.VehicleThrottle = math.Float32frombits(binary.LittleEndian.Uint32(b[116:120]))
-	p.VehicleSteering = math.Float32frombits(binary.LittleEndian.Uint32(b[120:124]))
-	p.VehicleBrake = math.Float32frombits(binary.LittleEndian.Uint32(b[124:128]))
-	p.VehicleClutch = math.Float32frombits(binary.LittleEndian.Uint32(b[128:132]))
-	p.VehicleGear = math.Float32frombits(binary.LittleEndian.Uint32(b[132:136]))
-	p.GforceLat = math.Float32frombits(binary.LittleEndian.Uint32(b[136:140]))
-	p.GforceLon = math.Float32frombits(binary.LittleEndian.Uint32(b[140:144]))
-	p.Lap = math.Float32frombits(binary.LittleEndian.Uint32(b[144:148]))
-	p.EngineRate = math.Float32frombits(binary.LittleEndian.Uint32(b[148:152]))
-	p.SliProNativeSupport = math.Float32frombits(binary.LittleEndian.Uint32(b[152:156]))
-	p.CarPosition = math.Float32frombits(binary.LittleEndian.Uint32(b[156:160]))
-	p.KersLevel = math.Float32frombits(binary.LittleEndian.Uint32(b[160:164]))
-	p.KersMaxLevel = math.Float32frombits(binary.LittleEndian.Uint32(b[164:168]))
-	p.Drs = math.Float32frombits(binary.LittleEndian.Uint32(b[168:172]))
-	p.TractionControl = math.Float32frombits(binary.LittleEndian.Uint32(b[172:176]))
-	p.AntiLockBrakes = math.Float32frombits(binary.LittleEndian.Uint32(b[176:180]))
-	p.FuelInTank = math.Float32frombits(binary.LittleEndian.Uint32(b[180:184]))
-	p.FuelCapacity = math.Float32frombits(binary.LittleEndian.Uint32(b[184:188]))
-	p.InPits = math.Float32frombits(binary.LittleEndian.Uint32(b[188:192]))
-	p.Sector = math.Float32frombits(binary.LittleEndian.Uint32(b[192:196]))
-	p.Sector1Time = math.Float32frombits(binary.LittleEndian.Uint32(b[196:200]))
-	p.Sector2Time = math.Float32frombits(binary.LittleEndian.Uint32(b[200:204]))
-	p.BrakesTemp[0] = math.Float32frombits(binary.LittleEndian.Uint32(b[204:208]))
-	p.BrakesTemp[1] = math.Float32frombits(binary.LittleEndian.Uint32(b[208:212]))
-	p.BrakesTemp[2] = math.Float32frombits(binary.LittleEndian.Uint32(b[212:216]))
-	p.BrakesTemp[3] = math.Float32frombits(binary.LittleEndian.Uint32(b[216:220]))
-	p.WheelsPressure[0] = math.Float32frombits(binary.LittleEndian.Uint32(b[220:224]))
-	p.WheelsPressure[1] = math.Float32frombits(binary.LittleEndian.Uint32(b[224:228]))
-	p.WheelsPressure[2] = math.Float32frombits(binary.LittleEndian.Uint32(b[228:232]))
-	p.WheelsPressure[3] = math.Float32frombits(binary.LittleEndian.Uint32(b[232:236]))
-	p.TeamInfo = math.Float32frombits(binary.LittleEndian.Uint32(b[236:240]))
-	p.TotalLaps = math.Float32frombits(binary.LittleEndian.Uint32(b[240:244]))
-	p.TrackSize = math.Float32frombits(binary.LittleEndian.Uint32(b[244:248]))
-	p.LastLapTime = math.Float32frombits(binary.LittleEndian.Uint32(b[248:252]))
-	p.MaxRpm = math.Float32frombits(binary.LittleEndian.Uint32(b[252:256]))
+	level, err := dirtSeriesExtraData(len(b))
+	if err != nil {
+		return err
+	}
+	*p = PacketDirtSeries{ExtraData: level, size: len(b)}
+	p.layout(&codec{b: b})
 	return nil
 }
 
+// MarshalBinary encodes the fields of the ExtraData level (264 bytes for level 3),
+// packets decoded by UnmarshalBinary are encoded with their received size.
+func (p *PacketDirtSeries) MarshalBinary() ([]byte, error) {
+	n := p.size
+	if n == 0 {
+		if p.ExtraData < 0 || p.ExtraData > 3 {
+			return nil, fmt.Errorf("invalid extradata level: %d", p.ExtraData)
+		}
+		n = dirtSeriesLength(p.ExtraData)
+	}
+	b := make([]byte, n)
+	p.layout(&codec{b: b, write: true})
+	return b, nil
+}
+
+// dirtSeriesExtraData returns the extradata level of a packet of size n.
+func dirtSeriesExtraData(n int) (int, error) {
+	level := dirtSeriesLevel(n)
+	if level < 0 {
+		return 0, fmt.Errorf("invalid packet size: %d", n)
+	}
+	return level, nil
+}
+
+// dirtSeriesLevel returns the extradata level of a packet of size n, -1 if too short.
+func dirtSeriesLevel(n int) int {
+	switch {
+	case n >= PacketDirtSeriesExtraData3Length:
+		return 3
+	case n >= PacketDirtSeriesExtraData2Length:
+		return 2
+	case n >= PacketDirtSeriesExtraData1Length:
+		return 1
+	case n >= PacketDirtSeriesExtraData0Length:
+		return 0
+	}
+	return -1
+}
+
+// dirtSeriesLength returns the packet size of an extradata level.
+func dirtSeriesLength(level int) int {
+	switch level {
+	case 0:
+		return PacketDirtSeriesExtraData0Length
+	case 1:
+		return PacketDirtSeriesExtraData1Length
+	case 2:
+		return PacketDirtSeriesExtraData2Length
+	}
+	return PacketDirtSeriesExtraData3Length
+}
+
+// layout lists the fields in wire order, shorter packets end early.
+func (p *PacketDirtSeries) layout(c *codec) {
+	c.f32(&p.Time)
+	c.f32(&p.LapTime)
+	c.f32(&p.LapDistance)
+	c.f32(&p.TotalDistance)
+	c.f32(&p.VehiclePosX)
+	c.f32(&p.VehiclePosY)
+	c.f32(&p.VehiclePosZ)
+	c.f32(&p.VehicleSpeed)
+	c.f32(&p.VehicleVelX)
+	c.f32(&p.VehicleVelY)
+	c.f32(&p.VehicleVelZ)
+	c.f32(&p.VehicleRightDirectionX)
+	c.f32(&p.VehicleRightDirectionY)
+	c.f32(&p.VehicleRightDirectionZ)
+	c.f32(&p.VehicleForwardDirectionX)
+	c.f32(&p.VehicleForwardDirectionY)
+	c.f32(&p.VehicleForwardDirectionZ)
+	c.f32(&p.SuspPosBl)
+	c.f32(&p.SuspPosBr)
+	c.f32(&p.SuspPosFl)
+	c.f32(&p.SuspPosFr)
+	c.f32(&p.SuspVelBl)
+	c.f32(&p.SuspVelBr)
+	c.f32(&p.SuspVelFl)
+	c.f32(&p.SuspVelFr)
+	c.f32(&p.WheelSpeedBl)
+	c.f32(&p.WheelSpeedBr)
+	c.f32(&p.WheelSpeedFl)
+	c.f32(&p.WheelSpeedFr)
+	c.f32(&p.VehicleThrottle)
+	c.f32(&p.VehicleSteering)
+	c.f32(&p.VehicleBrake)
+	c.f32(&p.VehicleClutch)
+	c.f32(&p.VehicleGear)
+	c.f32(&p.GforceLat)
+	c.f32(&p.GforceLon)
+	c.f32(&p.Lap)
+	c.f32(&p.EngineRate)
+	c.f32(&p.SliProNativeSupport)
+	c.f32(&p.CarPosition)
+	c.f32(&p.KersLevel)
+	c.f32(&p.KersMaxLevel)
+	c.f32(&p.Drs)
+	c.f32(&p.TractionControl)
+	c.f32(&p.AntiLockBrakes)
+	c.f32(&p.FuelInTank)
+	c.f32(&p.FuelCapacity)
+	c.f32(&p.InPits)
+	c.f32(&p.Sector)
+	c.f32(&p.Sector1Time)
+	c.f32(&p.Sector2Time)
+	c.f32(&p.BrakesTemp[0])
+	c.f32(&p.BrakesTemp[1])
+	c.f32(&p.BrakesTemp[2])
+	c.f32(&p.BrakesTemp[3])
+	c.f32(&p.WheelsPressure[0])
+	c.f32(&p.WheelsPressure[1])
+	c.f32(&p.WheelsPressure[2])
+	c.f32(&p.WheelsPressure[3])
+	c.f32(&p.TeamInfo)
+	c.f32(&p.TotalLaps)
+	c.f32(&p.TrackSize)
+	c.f32(&p.LastLapTime)
+	c.f32(&p.MaxRpm)
+	c.f32(&p.IdleRpm)
+	c.f32(&p.MaxGears)
+	c.f32(&p.SessionType)
+	c.f32(&p.DrsAllowed)
+	c.f32(&p.TrackNumber)
+	c.f32(&p.VehicleFIAFlags)
+}
+
+func (p *PacketDirtSeries) Format() Format {
+	return FormatDirtSeries
+}
+
+func (p *PacketDirtSeries) Frame(f *Frame) {
+	*f = Frame{
+		Format:    FormatDirtSeries,
+		Available: ChannelSpeed | ChannelPosition | ChannelVelocity,
+		Speed:     p.VehicleSpeed,
+		Position:  Vec3{p.VehiclePosX, p.VehiclePosY, p.VehiclePosZ},
+		Velocity:  Vec3{p.VehicleVelX, p.VehicleVelY, p.VehicleVelZ},
+		LapTime:   p.LapTime,
+	}
+	if p.ExtraData < 1 {
+		return
+	}
+	f.Available |= ChannelInputs | ChannelEngine | ChannelOrientation | ChannelGForce |
+		ChannelSuspension | ChannelWheelSpeed | ChannelLap
+	f.Steering = p.VehicleSteering
+	f.Throttle = p.VehicleThrottle
+	f.Brake = p.VehicleBrake
+	f.Clutch = p.VehicleClutch
+	f.Gear = p.Gear()
+	f.MaxGear = p.MaxGear()
+	f.RPM = p.EngineRate
+	f.Right = Vec3{p.VehicleRightDirectionX, p.VehicleRightDirectionY, p.VehicleRightDirectionZ}
+	f.Forward = Vec3{p.VehicleForwardDirectionX, p.VehicleForwardDirectionY, p.VehicleForwardDirectionZ}
+	f.Up = f.Forward.Cross(f.Right)
+	f.GForceLateral = p.GforceLat
+	f.GForceLongitudinal = p.GforceLon
+	f.SuspensionPosition = Wheels{p.SuspPosFl / 1000, p.SuspPosFr / 1000, p.SuspPosBl / 1000, p.SuspPosBr / 1000}
+	f.SuspensionVelocity = Wheels{p.SuspVelFl / 1000, p.SuspVelFr / 1000, p.SuspVelBl / 1000, p.SuspVelBr / 1000}
+	f.WheelSpeed = Wheels{p.WheelSpeedFl, p.WheelSpeedFr, p.WheelSpeedBl, p.WheelSpeedBr}
+	f.Lap = int(p.Lap)
+	f.LapDistance = p.LapDistance
+	if p.ExtraData < 2 {
+		return
+	}
+	f.Available |= ChannelBrakeTemperature
+	f.BrakeTemperature = Wheels{p.BrakesTemp[2], p.BrakesTemp[3], p.BrakesTemp[0], p.BrakesTemp[1]}
+	f.MaxRPM = p.MaxRpm
+	f.IdleRPM = p.IdleRpm
+	f.setStage(p.LapTime, p.LapDistance, p.TrackSize)
+}
+
 func (p *PacketDirtSeries) Steering() float32 {
 	return p.VehicleSteering
 }
@@ -170,8 +352,16 @@ func (p *PacketDirtSeries) Handbrake() float32 {
 	return 0
 }
 
+// Gear returns the normalized gear, some titles send 10 for reverse.
 func (p *PacketDirtSeries) Gear() int {
-	return int(p.VehicleGear)
+	if p.VehicleGear == 10 {
+		return -1
+	}
+	return normalizeGear(int(p.VehicleGear), 0, -1)
+}
+
+func (p *PacketDirtSeries) MaxGear() int {
+	return int(p.MaxGears)
 }
 
 func (p *PacketDirtSeries) RPM() float32 {
@@ -0,0 +1,112 @@
+package codemasters
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDetectDirtSeries(t *testing.T) {
+	for _, c := range []struct {
+		size  int
+		score int
+	}{
+		{PacketDirtSeriesExtraData0Length - 1, 0},
+		{PacketDirtSeriesExtraData0Length, 60},
+		{PacketDirtSeriesExtraData0Length + 4, 60 - dirtSeriesNonCanonical},
+		{PacketDirtSeriesExtraData1Length, 90},
+		{PacketDirtSeriesExtraData1Length + 4, 90 - dirtSeriesNonCanonical},
+		{PacketDirtSeriesExtraData2Length, 90},
+		{PacketDirtSeriesExtraData3Length, 90},
+		{PacketDirtSeriesExtraData3Length + 4, 90 - dirtSeriesNonCanonical},
+		{PacketDirtSeriesFullLength, 90},
+		{PacketDirtSeriesFullLength + 8, 90 - dirtSeriesNonCanonical},
+	} {
+		b := make([]byte, c.size)
+		copy(b, dirtSeriesPacket(t, 3))
+		if score := detectDirtSeries(b); score != c.score {
+			t.Errorf("%d bytes: score %d, want %d", c.size, score, c.score)
+		}
+	}
+}
+
+func TestDetectDirtSeriesJunk(t *testing.T) {
+	for _, size := range []int{
+		PacketDirtSeriesExtraData0Length,
+		PacketDirtSeriesExtraData1Length,
+		PacketDirtSeriesExtraData2Length,
+		PacketDirtSeriesExtraData3Length,
+		PacketDirtSeriesFullLength,
+	} {
+		if score := detectDirtSeries(make([]byte, size)); score >= MinConfidence {
+			t.Errorf("%d zero bytes: score %d", size, score)
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	n := 0
+	for i := 0; i < 10000; i++ {
+		b := make([]byte, PacketDirtSeriesExtraData0Length+r.Intn(PacketDirtSeriesFullLength-PacketDirtSeriesExtraData0Length))
+		r.Read(b)
+		if detectDirtSeries(b) >= MinConfidence {
+			n++
+		}
+	}
+	if n > 0 {
+		t.Errorf("%d of 10000 random packets detected", n)
+	}
+}
+
+func TestDecodeDirtSeriesLonger(t *testing.T) {
+	b := make([]byte, PacketDirtSeriesExtraData2Length+4) // zero IdleRpm
+	copy(b, dirtSeriesPacket(t, 3)[:PacketDirtSeriesExtraData2Length])
+	pkt, format, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pkt.(*PacketDirtSeries)
+	if format != FormatDirtSeries || p.ExtraData != 2 || p.MaxRpm != 800 || p.IdleRpm != 0 {
+		t.Errorf("format %q extradata %d max rpm %v idle rpm %v", format, p.ExtraData, p.MaxRpm, p.IdleRpm)
+	}
+}
+
+func TestDirtSeriesRoundTrip(t *testing.T) {
+	for level, size := range []int{
+		PacketDirtSeriesExtraData0Length,
+		PacketDirtSeriesExtraData1Length,
+		PacketDirtSeriesExtraData2Length,
+		PacketDirtSeriesExtraData3Length,
+	} {
+		b := dirtSeriesPacket(t, level)
+		if len(b) != size {
+			t.Errorf("extradata %d: %d bytes, want %d", level, len(b), size)
+		}
+		var p PacketDirtSeries
+		if err := p.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if p.ExtraData != level || p.Time != 12.5 || p.VehicleSpeed != 25 {
+			t.Errorf("extradata %d: decoded %+v", level, p)
+		}
+		out, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(b) {
+			t.Errorf("extradata %d: re-encoded %d bytes differ", level, len(out))
+		}
+	}
+
+	// packets keep their received size, e.g. the F1 legacy mode
+	b := make([]byte, PacketDirtSeriesFullLength)
+	copy(b, dirtSeriesPacket(t, 3))
+	var p PacketDirtSeries
+	if err := p.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := p.MarshalBinary(); err != nil || len(out) != PacketDirtSeriesFullLength {
+		t.Errorf("full packet: %d bytes, %v", len(out), err)
+	}
+
+	if _, err := (&PacketDirtSeries{ExtraData: 4}).MarshalBinary(); err == nil {
+		t.Error("extradata 4: no error")
+	}
+}
@@ -8,6 +8,32 @@ import (
 
 const PacketEASportsWRCLength = 237
 
+func init() {
+	Register(FormatSpec{
+		Format: FormatEASportsWRC,
+		Detect: detectEASportsWRC,
+		New:    func() Packet { return &PacketEASportsWRC{} },
+	})
+}
+
+// detectEASportsWRC checks the packet size, gear indices and driver inputs.
+func detectEASportsWRC(b []byte) int {
+	if len(b) != PacketEASportsWRCLength {
+		return 0
+	}
+	f := func(off int) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[off : off+4]))
+	}
+	if b[40] > 10 || b[37] > b[40] && b[37] != b[39] { // gear maximum, gear index
+		return 20
+	}
+	if plausible(f(197), 0, 1) && plausible(f(201), 0, 1) && plausible(f(205), 0, 1) &&
+		plausible(f(209), -1, 1) && plausible(f(213), 0, 1) { // throttle, brake, clutch, steering, handbrake
+		return 95
+	}
+	return 20
+}
+
 type PacketEASportsWRC struct {
 	PacketUid                 uint64  // 0
 	GameTotalTime             float32 // 1
@@ -74,68 +100,115 @@ func (p *PacketEASportsWRC) UnmarshalBinary(b []byte) error {
 	if len(b) < PacketEASportsWRCLength {
 		return fmt.Errorf("invalid packet size: %d", len(b))
 	}
-	p.PacketUid = binary.LittleEndian.Uint64(b[0:8])
-	p.GameTotalTime = math.Float32frombits(binary.LittleEndian.Uint32(b[8:12]))
-	p.GameDeltaTime = math.Float32frombits(binary.LittleEndian.Uint32(b[12:16]))
-	p.GameFrameCount = binary.LittleEndian.Uint64(b[16:24])
-	p.ShiftlightsFraction = math.Float32frombits(binary.LittleEndian.Uint32(b[24:28]))
-	p.ShiftlightsRpmStart = math.Float32frombits(binary.LittleEndian.Uint32(b[28:32]))
-	p.ShiftlightsRpmEnd = math.Float32frombits(binary.LittleEndian.Uint32(b[32:36]))
-	p.ShiftlightsRpmValid = b[36] != 0
-	p.VehicleGearIndex = b[37]
-	p.VehicleGearIndexNeutral = b[38]
-	p.VehicleGearIndexReverse = b[39]
-	p.VehicleGearMaximum = b[40]
-	p.VehicleSpeed = math.Float32frombits(binary.LittleEndian.Uint32(b[41:45]))
-	p.VehicleTransmissionSpeed = math.Float32frombits(binary.LittleEndian.Uint32(b[45:49]))
-	p.VehiclePositionX = math.Float32frombits(binary.LittleEndian.Uint32(b[49:53]))
-	p.VehiclePositionY = math.Float32frombits(binary.LittleEndian.Uint32(b[53:57]))
-	p.VehiclePositionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[57:61]))
-	p.VehicleVelocityX = math.Float32frombits(binary.LittleEndian.Uint32(b[61:65]))
-	p.VehicleVelocityY = math.Float32frombits(binary.LittleEndian.Uint32(b[65:69]))
-	p.VehicleVelocityZ = math.Float32frombits(binary.LittleEndian.Uint32(b[69:73]))
-	p.VehicleAccelerationX = math.Float32frombits(binary.LittleEndian.Uint32(b[73:77]))
-	p.VehicleAccelerationY = math.Float32frombits(binary.LittleEndian.Uint32(b[77:81]))
-	p.VehicleAccelerationZ = math.Float32frombits(binary.LittleEndian.Uint32(b[81:85]))
-	p.VehicleLeftDirectionX = math.Float32frombits(binary.LittleEndian.Uint32(b[85:89]))
-	p.VehicleLeftDirectionY = math.Float32frombits(binary.LittleEndian.Uint32(b[89:93]))
-	p.VehicleLeftDirectionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[93:97]))
-	p.VehicleForwardDirectionX = math.Float32frombits(binary.LittleEndian.Uint32(b[97:101]))
-	p.VehicleForwardDirectionY = math.Float32frombits(binary.LittleEndian.Uint32(b[101:105]))
-	p.VehicleForwardDirectionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[105:109]))
-	p.VehicleUpDirectionX = math.Float32frombits(binary.LittleEndian.Uint32(b[109:113]))
-	p.VehicleUpDirectionY = math.Float32frombits(binary.LittleEndian.Uint32(b[113:117]))
-	p.VehicleUpDirectionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[117:121]))
-	p.VehicleHubPositionBl = math.Float32frombits(binary.LittleEndian.Uint32(b[121:125]))
-	p.VehicleHubPositionBr = math.Float32frombits(binary.LittleEndian.Uint32(b[125:129]))
-	p.VehicleHubPositionFl = math.Float32frombits(binary.LittleEndian.Uint32(b[129:133]))
-	p.VehicleHubPositionFr = math.Float32frombits(binary.LittleEndian.Uint32(b[133:137]))
-	p.VehicleHubVelocityBl = math.Float32frombits(binary.LittleEndian.Uint32(b[137:141]))
-	p.VehicleHubVelocityBr = math.Float32frombits(binary.LittleEndian.Uint32(b[141:145]))
-	p.VehicleHubVelocityFl = math.Float32frombits(binary.LittleEndian.Uint32(b[145:149]))
-	p.VehicleHubVelocityFr = math.Float32frombits(binary.LittleEndian.Uint32(b[149:153]))
-	p.VehicleCpForwardSpeedBl = math.Float32frombits(binary.LittleEndian.Uint32(b[153:157]))
-	p.VehicleCpForwardSpeedBr = math.Float32frombits(binary.LittleEndian.Uint32(b[157:161]))
-	p.VehicleCpForwardSpeedFl = math.Float32frombits(binary.LittleEndian.Uint32(b[161:165]))
-	p.VehicleCpForwardSpeedFr = math.Float32frombits(binary.LittleEndian.Uint32(b[165:169]))
-	p.VehicleBrakeTemperatureBl = math.Float32frombits(binary.LittleEndian.Uint32(b[169:173]))
-	p.VehicleBrakeTemperatureBr = math.Float32frombits(binary.LittleEndian.Uint32(b[173:177]))
-	p.VehicleBrakeTemperatureFl = math.Float32frombits(binary.LittleEndian.Uint32(b[177:181]))
-	p.VehicleBrakeTemperatureFr = math.Float32frombits(binary.LittleEndian.Uint32(b[181:185]))
-	p.VehicleEngineRpmMax = math.Float32frombits(binary.LittleEndian.Uint32(b[185:189]))
-	p.VehicleEngineRpmIdle = math.Float32frombits(binary.LittleEndian.Uint32(b[189:193]))
-	p.VehicleEngineRpmCurrent = math.Float32frombits(binary.LittleEndian.Uint32(b[193:197]))
-	p.VehicleThrottle = math.Float32frombits(binary.LittleEndian.Uint32(b[197:201]))
-	p.VehicleBrake = math.Float32frombits(binary.LittleEndian.Uint32(b[201:205]))
-	p.VehicleClutch = math.Float32frombits(binary.LittleEndian.Uint32(b[205:209]))
-	p.VehicleSteering = math.Float32frombits(binary.LittleEndian.Uint32(b[209:213]))
-	p.VehicleHandbrake = math.Float32frombits(binary.LittleEndian.Uint32(b[213:217]))
-	p.StageCurrentTime = math.Float32frombits(binary.LittleEndian.Uint32(b[217:221]))
-	p.StageCurrentDistance = math.Float64frombits(binary.LittleEndian.Uint64(b[221:229]))
-	p.StageLength = math.Float64frombits(binary.LittleEndian.Uint64(b[229:237]))
+	p.layout(&codec{b: b})
 	return nil
 }
 
+func (p *PacketEASportsWRC) MarshalBinary() ([]byte, error) {
+	b := make([]byte, PacketEASportsWRCLength)
+	p.layout(&codec{b: b, write: true})
+	return b, nil
+}
+
+// layout lists the fields in wire order.
+func (p *PacketEASportsWRC) layout(c *codec) {
+	c.u64(&p.PacketUid)
+	c.f32(&p.GameTotalTime)
+	c.f32(&p.GameDeltaTime)
+	c.u64(&p.GameFrameCount)
+	c.f32(&p.ShiftlightsFraction)
+	c.f32(&p.ShiftlightsRpmStart)
+	c.f32(&p.ShiftlightsRpmEnd)
+	c.bool(&p.ShiftlightsRpmValid)
+	c.u8(&p.VehicleGearIndex)
+	c.u8(&p.VehicleGearIndexNeutral)
+	c.u8(&p.VehicleGearIndexReverse)
+	c.u8(&p.VehicleGearMaximum)
+	c.f32(&p.VehicleSpeed)
+	c.f32(&p.VehicleTransmissionSpeed)
+	c.f32(&p.VehiclePositionX)
+	c.f32(&p.VehiclePositionY)
+	c.f32(&p.VehiclePositionZ)
+	c.f32(&p.VehicleVelocityX)
+	c.f32(&p.VehicleVelocityY)
+	c.f32(&p.VehicleVelocityZ)
+	c.f32(&p.VehicleAccelerationX)
+	c.f32(&p.VehicleAccelerationY)
+	c.f32(&p.VehicleAccelerationZ)
+	c.f32(&p.VehicleLeftDirectionX)
+	c.f32(&p.VehicleLeftDirectionY)
+	c.f32(&p.VehicleLeftDirectionZ)
+	c.f32(&p.VehicleForwardDirectionX)
+	c.f32(&p.VehicleForwardDirectionY)
+	c.f32(&p.VehicleForwardDirectionZ)
+	c.f32(&p.VehicleUpDirectionX)
+	c.f32(&p.VehicleUpDirectionY)
+	c.f32(&p.VehicleUpDirectionZ)
+	c.f32(&p.VehicleHubPositionBl)
+	c.f32(&p.VehicleHubPositionBr)
+	c.f32(&p.VehicleHubPositionFl)
+	c.f32(&p.VehicleHubPositionFr)
+	c.f32(&p.VehicleHubVelocityBl)
+	c.f32(&p.VehicleHubVelocityBr)
+	c.f32(&p.VehicleHubVelocityFl)
+	c.f32(&p.VehicleHubVelocityFr)
+	c.f32(&p.VehicleCpForwardSpeedBl)
+	c.f32(&p.VehicleCpForwardSpeedBr)
+	c.f32(&p.VehicleCpForwardSpeedFl)
+	c.f32(&p.VehicleCpForwardSpeedFr)
+	c.f32(&p.VehicleBrakeTemperatureBl)
+	c.f32(&p.VehicleBrakeTemperatureBr)
+	c.f32(&p.VehicleBrakeTemperatureFl)
+	c.f32(&p.VehicleBrakeTemperatureFr)
+	c.f32(&p.VehicleEngineRpmMax)
+	c.f32(&p.VehicleEngineRpmIdle)
+	c.f32(&p.VehicleEngineRpmCurrent)
+	c.f32(&p.VehicleThrottle)
+	c.f32(&p.VehicleBrake)
+	c.f32(&p.VehicleClutch)
+	c.f32(&p.VehicleSteering)
+	c.f32(&p.VehicleHandbrake)
+	c.f32(&p.StageCurrentTime)
+	c.f64(&p.StageCurrentDistance)
+	c.f64(&p.StageLength)
+}
+
+func (p *PacketEASportsWRC) Format() Format {
+	return FormatEASportsWRC
+}
+
+func (p *PacketEASportsWRC) Frame(f *Frame) {
+	*f = Frame{
+		Format: FormatEASportsWRC,
+		Available: ChannelInputs | ChannelHandbrake | ChannelEngine | ChannelSpeed |
+			ChannelPosition | ChannelVelocity | ChannelAcceleration | ChannelOrientation |
+			ChannelSuspension | ChannelWheelSpeed | ChannelBrakeTemperature,
+		Steering:           p.VehicleSteering,
+		Throttle:           p.VehicleThrottle,
+		Brake:              p.VehicleBrake,
+		Clutch:             p.VehicleClutch,
+		Handbrake:          p.VehicleHandbrake,
+		Gear:               p.Gear(),
+		MaxGear:            p.MaxGear(),
+		RPM:                p.VehicleEngineRpmCurrent,
+		MaxRPM:             p.VehicleEngineRpmMax,
+		IdleRPM:            p.VehicleEngineRpmIdle,
+		Speed:              p.VehicleSpeed,
+		Position:           Vec3{p.VehiclePositionX, p.VehiclePositionY, p.VehiclePositionZ},
+		Velocity:           Vec3{p.VehicleVelocityX, p.VehicleVelocityY, p.VehicleVelocityZ},
+		Acceleration:       Vec3{p.VehicleAccelerationX, p.VehicleAccelerationY, p.VehicleAccelerationZ},
+		Forward:            Vec3{p.VehicleForwardDirectionX, p.VehicleForwardDirectionY, p.VehicleForwardDirectionZ},
+		Right:              Vec3{-p.VehicleLeftDirectionX, -p.VehicleLeftDirectionY, -p.VehicleLeftDirectionZ},
+		Up:                 Vec3{p.VehicleUpDirectionX, p.VehicleUpDirectionY, p.VehicleUpDirectionZ},
+		SuspensionPosition: Wheels{p.VehicleHubPositionFl, p.VehicleHubPositionFr, p.VehicleHubPositionBl, p.VehicleHubPositionBr},
+		SuspensionVelocity: Wheels{p.VehicleHubVelocityFl, p.VehicleHubVelocityFr, p.VehicleHubVelocityBl, p.VehicleHubVelocityBr},
+		WheelSpeed:         Wheels{p.VehicleCpForwardSpeedFl, p.VehicleCpForwardSpeedFr, p.VehicleCpForwardSpeedBl, p.VehicleCpForwardSpeedBr},
+		BrakeTemperature:   Wheels{p.VehicleBrakeTemperatureFl, p.VehicleBrakeTemperatureFr, p.VehicleBrakeTemperatureBl, p.VehicleBrakeTemperatureBr},
+	}
+	f.setGForce(f.Acceleration)
+	f.setStage(p.StageCurrentTime, float32(p.StageCurrentDistance), float32(p.StageLength))
+}
+
 func (p *PacketEASportsWRC) Steering() float32 {
 	return p.VehicleSteering
 }
@@ -157,7 +230,12 @@ func (p *PacketEASportsWRC) Handbrake() float32 {
 }
 
 func (p *PacketEASportsWRC) Gear() int {
-	return int(p.VehicleGearIndex)
+	return normalizeGear(int(p.VehicleGearIndex), int(p.VehicleGearIndexNeutral), int(p.VehicleGearIndexReverse))
+}
+
+// MaxGear returns the number of forward gears, VehicleGearMaximum is the highest forward gear index.
+func (p *PacketEASportsWRC) MaxGear() int {
+	return normalizeGear(int(p.VehicleGearMaximum), int(p.VehicleGearIndexNeutral), -1)
 }
 
 func (p *PacketEASportsWRC) RPM() float32 {
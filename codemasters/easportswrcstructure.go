@@ -0,0 +1,521 @@
+package codemasters
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// EASportsWRCChannel is an entry of the game's telemetry channels.json.
+type EASportsWRCChannel struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Units       string `json:"units,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var easportsWRCTypeSizes = map[string]int{
+	"boolean": 1,
+	"fourcc":  4,
+	"int8":    1,
+	"uint8":   1,
+	"int16":   2,
+	"uint16":  2,
+	"int32":   4,
+	"uint32":  4,
+	"float32": 4,
+	"int64":   8,
+	"uint64":  8,
+	"float64": 8,
+}
+
+var (
+	easportsWRCChannelsMu sync.RWMutex
+	easportsWRCChannels   = map[string]string{
+		"packet_4cc":                   "fourcc",
+		"packet_uid":                   "uint64",
+		"game_total_time":              "float32",
+		"game_delta_time":              "float32",
+		"game_frame_count":             "uint64",
+		"game_mode":                    "uint8",
+		"location_id":                  "uint16",
+		"route_id":                     "uint16",
+		"stage_length":                 "float64",
+		"stage_shakedown":              "boolean",
+		"stage_current_time":           "float32",
+		"stage_current_distance":       "float64",
+		"stage_previous_split_time":    "float32",
+		"stage_result_status":          "uint8",
+		"stage_result_time":            "float32",
+		"stage_result_time_penalty":    "float32",
+		"shiftlights_fraction":         "float32",
+		"shiftlights_rpm_start":        "float32",
+		"shiftlights_rpm_end":          "float32",
+		"shiftlights_rpm_valid":        "boolean",
+		"vehicle_class_id":             "uint16",
+		"vehicle_id":                   "uint16",
+		"vehicle_manufacturer_id":      "uint16",
+		"vehicle_gear_index":           "uint8",
+		"vehicle_gear_index_neutral":   "uint8",
+		"vehicle_gear_index_reverse":   "uint8",
+		"vehicle_gear_maximum":         "uint8",
+		"vehicle_speed":                "float32",
+		"vehicle_transmission_speed":   "float32",
+		"vehicle_position_x":           "float32",
+		"vehicle_position_y":           "float32",
+		"vehicle_position_z":           "float32",
+		"vehicle_velocity_x":           "float32",
+		"vehicle_velocity_y":           "float32",
+		"vehicle_velocity_z":           "float32",
+		"vehicle_acceleration_x":       "float32",
+		"vehicle_acceleration_y":       "float32",
+		"vehicle_acceleration_z":       "float32",
+		"vehicle_left_direction_x":     "float32",
+		"vehicle_left_direction_y":     "float32",
+		"vehicle_left_direction_z":     "float32",
+		"vehicle_forward_direction_x":  "float32",
+		"vehicle_forward_direction_y":  "float32",
+		"vehicle_forward_direction_z":  "float32",
+		"vehicle_up_direction_x":       "float32",
+		"vehicle_up_direction_y":       "float32",
+		"vehicle_up_direction_z":       "float32",
+		"vehicle_hub_position_bl":      "float32",
+		"vehicle_hub_position_br":      "float32",
+		"vehicle_hub_position_fl":      "float32",
+		"vehicle_hub_position_fr":      "float32",
+		"vehicle_hub_velocity_bl":      "float32",
+		"vehicle_hub_velocity_br":      "float32",
+		"vehicle_hub_velocity_fl":      "float32",
+		"vehicle_hub_velocity_fr":      "float32",
+		"vehicle_cp_forward_speed_bl":  "float32",
+		"vehicle_cp_forward_speed_br":  "float32",
+		"vehicle_cp_forward_speed_fl":  "float32",
+		"vehicle_cp_forward_speed_fr":  "float32",
+		"vehicle_brake_temperature_bl": "float32",
+		"vehicle_brake_temperature_br": "float32",
+		"vehicle_brake_temperature_fl": "float32",
+		"vehicle_brake_temperature_fr": "float32",
+		"vehicle_tyre_state_bl":        "uint8",
+		"vehicle_tyre_state_br":        "uint8",
+		"vehicle_tyre_state_fl":        "uint8",
+		"vehicle_tyre_state_fr":        "uint8",
+		"vehicle_engine_rpm_max":       "float32",
+		"vehicle_engine_rpm_idle":      "float32",
+		"vehicle_engine_rpm_current":   "float32",
+		"vehicle_throttle":             "float32",
+		"vehicle_brake":                "float32",
+		"vehicle_clutch":               "float32",
+		"vehicle_steering":             "float32",
+		"vehicle_handbrake":            "float32",
+	}
+)
+
+// four-CCs of the lifecycle packets, see PacketEASportsWRCSession
+var easportsWRCPacketFourCCs = map[string]string{
+	"session_start":  "sess",
+	"session_update": "sesu",
+	"session_pause":  "sesp",
+	"session_resume": "sesr",
+	"session_end":    "sese",
+}
+
+// ranges of the channels checked to detect packets without a four-CC
+var easportsWRCInputRanges = map[string][2]float64{
+	"vehicle_throttle":  {0, 1},
+	"vehicle_brake":     {0, 1},
+	"vehicle_clutch":    {0, 1},
+	"vehicle_handbrake": {0, 1},
+	"vehicle_steering":  {-1, 1},
+}
+
+// LoadEASportsWRCChannels reads the game's channels.json and adds
+// or overrides the channel types known to this package.
+// Nothing is changed if an entry is invalid.
+func LoadEASportsWRCChannels(r io.Reader) error {
+	var v struct {
+		Channels []EASportsWRCChannel `json:"channels"`
+	}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return err
+	}
+	for _, c := range v.Channels {
+		if _, ok := easportsWRCTypeSizes[c.Type]; !ok {
+			return fmt.Errorf("channel %q: unknown type %q", c.Id, c.Type)
+		}
+	}
+	easportsWRCChannelsMu.Lock()
+	defer easportsWRCChannelsMu.Unlock()
+	for _, c := range v.Channels {
+		easportsWRCChannels[c.Id] = c.Type
+	}
+	return nil
+}
+
+// EASportsWRCStructure is a custom packet structure as defined in
+// the game's telemetry udp/*.json files.
+type EASportsWRCStructure struct {
+	Id      string                       `json:"id"`
+	Packets []EASportsWRCStructurePacket `json:"packets"`
+
+	layouts []easportsWRCLayout
+}
+
+type EASportsWRCStructurePacket struct {
+	Id     string `json:"id"`
+	FourCC string `json:"4cc,omitempty"` // defaults to the lifecycle four-CC of Id
+	Header struct {
+		Channels []string `json:"channels"`
+	} `json:"header"`
+	Channels []string `json:"channels"`
+}
+
+type easportsWRCField struct {
+	channel string
+	typ     string
+	offset  int
+}
+
+type easportsWRCLayout struct {
+	id     string
+	fourCC string // empty if the packet has no packet_4cc channel
+	fields []easportsWRCField
+	inputs []easportsWRCField // fields checked by plausible
+	size   int
+}
+
+// LoadEASportsWRCStructure reads a structure definition and builds its decoder.
+func LoadEASportsWRCStructure(r io.Reader) (*EASportsWRCStructure, error) {
+	s := &EASportsWRCStructure{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *EASportsWRCStructure) compile() error {
+	easportsWRCChannelsMu.RLock()
+	defer easportsWRCChannelsMu.RUnlock()
+	s.layouts = s.layouts[:0]
+	for _, p := range s.Packets {
+		l := easportsWRCLayout{id: p.Id}
+		channels := append(append([]string{}, p.Header.Channels...), p.Channels...)
+		for _, c := range channels {
+			typ, ok := easportsWRCChannels[c]
+			if !ok {
+				return fmt.Errorf("structure %q packet %q: unknown channel %q", s.Id, p.Id, c)
+			}
+			if c == "packet_4cc" && l.size == 0 {
+				l.fourCC = p.FourCC
+				if l.fourCC == "" {
+					l.fourCC = easportsWRCPacketFourCCs[p.Id]
+				}
+				if len(l.fourCC) != 4 {
+					return fmt.Errorf("structure %q packet %q: no four-CC", s.Id, p.Id)
+				}
+			}
+			f := easportsWRCField{channel: c, typ: typ, offset: l.size}
+			l.fields = append(l.fields, f)
+			if _, ok := easportsWRCInputRanges[c]; ok {
+				l.inputs = append(l.inputs, f)
+			}
+			l.size += easportsWRCTypeSizes[typ]
+		}
+		if l.fourCC == "" && len(l.inputs) == 0 {
+			return fmt.Errorf("structure %q packet %q: no four-CC nor input channel to detect it", s.Id, p.Id)
+		}
+		s.layouts = append(s.layouts, l)
+	}
+	if len(s.layouts) == 0 {
+		return fmt.Errorf("structure %q: no packets", s.Id)
+	}
+	return nil
+}
+
+// Format returns the format the structure is registered as.
+func (s *EASportsWRCStructure) Format() Format {
+	return Format("easportswrc:" + s.Id)
+}
+
+// detect returns the layout matching b by four-CC or by size and plausible inputs.
+// Layouts without a four-CC replace the built-in layout of the same size,
+// for other sizes they only take packets every other format rejects.
+func (s *EASportsWRCStructure) detect(b []byte) (*easportsWRCLayout, int) {
+	var res *easportsWRCLayout
+	score := 0
+	for i := range s.layouts {
+		l := &s.layouts[i]
+		if len(b) != l.size {
+			continue
+		}
+		switch {
+		case l.fourCC != "" && string(b[:4]) == l.fourCC:
+			return l, 100
+		case l.fourCC == "" && l.plausible(b):
+			c := MinConfidence
+			if l.size == PacketEASportsWRCLength {
+				c = 98 // above the built-in layout
+			}
+			if c > score {
+				res, score = l, c
+			}
+		}
+	}
+	return res, score
+}
+
+// plausible reports whether the inputs of b are within their ranges.
+func (l *easportsWRCLayout) plausible(b []byte) bool {
+	for _, f := range l.inputs {
+		r := easportsWRCInputRanges[f.channel]
+		if v := f.value(b); !(v >= r[0] && v <= r[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// value decodes the field from the packet b.
+func (f *easportsWRCField) value(b []byte) float64 {
+	v := b[f.offset:]
+	switch f.typ {
+	case "boolean", "uint8":
+		return float64(v[0])
+	case "int8":
+		return float64(int8(v[0]))
+	case "uint16":
+		return float64(binary.LittleEndian.Uint16(v))
+	case "int16":
+		return float64(int16(binary.LittleEndian.Uint16(v)))
+	case "uint32":
+		return float64(binary.LittleEndian.Uint32(v))
+	case "int32":
+		return float64(int32(binary.LittleEndian.Uint32(v)))
+	case "float32":
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(v)))
+	case "uint64":
+		return float64(binary.LittleEndian.Uint64(v))
+	case "int64":
+		return float64(int64(binary.LittleEndian.Uint64(v)))
+	case "float64":
+		return math.Float64frombits(binary.LittleEndian.Uint64(v))
+	}
+	return 0
+}
+
+// RegisterEASportsWRCStructure registers s, its packets take precedence
+// over the built-in EA Sports WRC layouts.
+func RegisterEASportsWRCStructure(s *EASportsWRCStructure) error {
+	if len(s.layouts) == 0 {
+		if err := s.compile(); err != nil {
+			return err
+		}
+	}
+	Register(FormatSpec{
+		Format: s.Format(),
+		Detect: func(b []byte) int {
+			_, score := s.detect(b)
+			return score
+		},
+		New: func() Packet {
+			return &PacketEASportsWRCCustom{Structure: s, Values: map[string]float64{}}
+		},
+	})
+	return nil
+}
+
+// PacketEASportsWRCCustom holds the latest value of every channel
+// received through a custom structure.
+type PacketEASportsWRCCustom struct {
+	Structure *EASportsWRCStructure
+	PacketId  string             // id of the last decoded packet
+	Values    map[string]float64 // channel id to value, four-CCs are skipped
+}
+
+func (p *PacketEASportsWRCCustom) UnmarshalBinary(b []byte) error {
+	l, _ := p.Structure.detect(b)
+	if l == nil {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.PacketId = l.id
+	for i := range l.fields {
+		f := &l.fields[i]
+		if f.typ == "fourcc" {
+			continue
+		}
+		p.Values[f.channel] = f.value(b)
+	}
+	return nil
+}
+
+// Value returns the latest value of channel id.
+func (p *PacketEASportsWRCCustom) Value(id string) (float64, bool) {
+	v, ok := p.Values[id]
+	return v, ok
+}
+
+func (p *PacketEASportsWRCCustom) f32(id string) float32 {
+	return float32(p.Values[id])
+}
+
+// Event returns the lifecycle event of the last packet, packets which
+// are not lifecycle packets are reported as session updates.
+func (p *PacketEASportsWRCCustom) Event() Event {
+	if _, ok := easportsWRCPacketFourCCs[p.PacketId]; ok {
+		return Event(p.PacketId)
+	}
+	return EventSessionUpdate
+}
+
+func (p *PacketEASportsWRCCustom) SessionInfo() SessionInfo {
+	return SessionInfo{
+		GameMode:               int(p.Values["game_mode"]),
+		LocationId:             int(p.Values["location_id"]),
+		RouteId:                int(p.Values["route_id"]),
+		StageLength:            p.Values["stage_length"],
+		StageShakedown:         p.Values["stage_shakedown"] != 0,
+		VehicleClassId:         int(p.Values["vehicle_class_id"]),
+		VehicleId:              int(p.Values["vehicle_id"]),
+		VehicleManufacturerId:  int(p.Values["vehicle_manufacturer_id"]),
+		StageResultStatus:      int(p.Values["stage_result_status"]),
+		StageResultTime:        p.f32("stage_result_time"),
+		StageResultTimePenalty: p.f32("stage_result_time_penalty"),
+	}
+}
+
+// Format returns FormatEASportsWRC, custom structures share its profile.
+func (p *PacketEASportsWRCCustom) Format() Format {
+	return FormatEASportsWRC
+}
+
+// has reports whether all channels ids were received.
+func (p *PacketEASportsWRCCustom) has(ids ...string) bool {
+	for _, id := range ids {
+		if _, ok := p.Values[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PacketEASportsWRCCustom) vec3(prefix string) Vec3 {
+	return Vec3{p.f32(prefix + "_x"), p.f32(prefix + "_y"), p.f32(prefix + "_z")}
+}
+
+func (p *PacketEASportsWRCCustom) wheels(prefix string) Wheels {
+	return Wheels{p.f32(prefix + "_fl"), p.f32(prefix + "_fr"), p.f32(prefix + "_bl"), p.f32(prefix + "_br")}
+}
+
+func (p *PacketEASportsWRCCustom) Frame(f *Frame) {
+	*f = Frame{Format: FormatEASportsWRC}
+	if p.has("vehicle_steering", "vehicle_throttle", "vehicle_brake", "vehicle_clutch") {
+		f.Available |= ChannelInputs
+		f.Steering, f.Throttle, f.Brake, f.Clutch = p.Steering(), p.Throttle(), p.Brake(), p.Clutch()
+	}
+	if p.has("vehicle_handbrake") {
+		f.Available |= ChannelHandbrake
+		f.Handbrake = p.Handbrake()
+	}
+	if p.has("vehicle_gear_index", "vehicle_engine_rpm_current") {
+		f.Available |= ChannelEngine
+		f.Gear, f.MaxGear, f.RPM = p.Gear(), p.MaxGear(), p.RPM()
+		f.MaxRPM, f.IdleRPM = p.MaxRPM(), p.f32("vehicle_engine_rpm_idle")
+	}
+	if p.has("vehicle_speed") {
+		f.Available |= ChannelSpeed
+		f.Speed = p.Speed()
+	}
+	if p.has("vehicle_position_x", "vehicle_position_y", "vehicle_position_z") {
+		f.Available |= ChannelPosition
+		f.Position = p.vec3("vehicle_position")
+	}
+	if p.has("vehicle_velocity_x", "vehicle_velocity_y", "vehicle_velocity_z") {
+		f.Available |= ChannelVelocity
+		f.Velocity = p.vec3("vehicle_velocity")
+	}
+	if p.has("vehicle_forward_direction_x", "vehicle_forward_direction_y", "vehicle_forward_direction_z",
+		"vehicle_left_direction_x", "vehicle_left_direction_y", "vehicle_left_direction_z") {
+		f.Available |= ChannelOrientation
+		f.Forward = p.vec3("vehicle_forward_direction")
+		f.Right = p.vec3("vehicle_left_direction").Scale(-1)
+		f.Up = f.Forward.Cross(f.Right)
+		if p.has("vehicle_up_direction_x", "vehicle_up_direction_y", "vehicle_up_direction_z") {
+			f.Up = p.vec3("vehicle_up_direction")
+		}
+		if p.has("vehicle_acceleration_x", "vehicle_acceleration_y", "vehicle_acceleration_z") {
+			f.Available |= ChannelAcceleration
+			f.Acceleration = p.vec3("vehicle_acceleration")
+			f.setGForce(f.Acceleration)
+		}
+	}
+	if p.has("vehicle_hub_position_fl", "vehicle_hub_position_fr", "vehicle_hub_position_bl", "vehicle_hub_position_br",
+		"vehicle_hub_velocity_fl", "vehicle_hub_velocity_fr", "vehicle_hub_velocity_bl", "vehicle_hub_velocity_br") {
+		f.Available |= ChannelSuspension
+		f.SuspensionPosition = p.wheels("vehicle_hub_position")
+		f.SuspensionVelocity = p.wheels("vehicle_hub_velocity")
+	}
+	if p.has("vehicle_cp_forward_speed_fl", "vehicle_cp_forward_speed_fr", "vehicle_cp_forward_speed_bl", "vehicle_cp_forward_speed_br") {
+		f.Available |= ChannelWheelSpeed
+		f.WheelSpeed = p.wheels("vehicle_cp_forward_speed")
+	}
+	if p.has("vehicle_brake_temperature_fl", "vehicle_brake_temperature_fr", "vehicle_brake_temperature_bl", "vehicle_brake_temperature_br") {
+		f.Available |= ChannelBrakeTemperature
+		f.BrakeTemperature = p.wheels("vehicle_brake_temperature")
+	}
+	if p.has("stage_current_time", "stage_current_distance", "stage_length") {
+		f.setStage(p.f32("stage_current_time"), p.f32("stage_current_distance"), p.f32("stage_length"))
+	}
+}
+
+func (p *PacketEASportsWRCCustom) Steering() float32 {
+	return p.f32("vehicle_steering")
+}
+
+func (p *PacketEASportsWRCCustom) Throttle() float32 {
+	return p.f32("vehicle_throttle")
+}
+
+func (p *PacketEASportsWRCCustom) Brake() float32 {
+	return p.f32("vehicle_brake")
+}
+
+func (p *PacketEASportsWRCCustom) Clutch() float32 {
+	return p.f32("vehicle_clutch")
+}
+
+func (p *PacketEASportsWRCCustom) Handbrake() float32 {
+	return p.f32("vehicle_handbrake")
+}
+
+func (p *PacketEASportsWRCCustom) Gear() int {
+	neutral, reverse := 0, -1
+	if p.has("vehicle_gear_index_neutral", "vehicle_gear_index_reverse") {
+		neutral, reverse = int(p.Values["vehicle_gear_index_neutral"]), int(p.Values["vehicle_gear_index_reverse"])
+	}
+	return normalizeGear(int(p.Values["vehicle_gear_index"]), neutral, reverse)
+}
+
+func (p *PacketEASportsWRCCustom) MaxGear() int {
+	return normalizeGear(int(p.Values["vehicle_gear_maximum"]), int(p.Values["vehicle_gear_index_neutral"]), -1)
+}
+
+func (p *PacketEASportsWRCCustom) RPM() float32 {
+	return p.f32("vehicle_engine_rpm_current")
+}
+
+func (p *PacketEASportsWRCCustom) MaxRPM() float32 {
+	return p.f32("vehicle_engine_rpm_max")
+}
+
+func (p *PacketEASportsWRCCustom) Speed() float32 {
+	return p.f32("vehicle_speed")
+}
+
+func (p *PacketEASportsWRCCustom) StageDistance() float32 {
+	return p.f32("stage_length")
+}
@@ -0,0 +1,87 @@
+package codemasters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadEASportsWRCStructureErrors(t *testing.T) {
+	for _, c := range []struct {
+		name, json string
+	}{
+		{"bad json", `{"id": "test", "packets": [`},
+		{"no packets", `{"id": "test", "packets": []}`},
+		{"unknown channel", `{"id": "test", "packets": [{"id": "session_update", "4cc": "test",
+			"header": {"channels": ["packet_4cc"]}, "channels": ["vehicle_warp_drive"]}]}`},
+		{"no four-CC", `{"id": "test", "packets": [{"id": "custom",
+			"header": {"channels": ["packet_4cc"]}, "channels": ["vehicle_speed"]}]}`},
+		{"undetectable", `{"id": "test", "packets": [{"id": "custom", "channels": ["vehicle_speed"]}]}`},
+	} {
+		if _, err := LoadEASportsWRCStructure(strings.NewReader(c.json)); err == nil {
+			t.Errorf("%s: no error", c.name)
+		}
+	}
+}
+
+func TestLoadEASportsWRCChannels(t *testing.T) {
+	t.Cleanup(func() {
+		easportsWRCChannelsMu.Lock()
+		delete(easportsWRCChannels, "test_channel")
+		delete(easportsWRCChannels, "test_other")
+		easportsWRCChannelsMu.Unlock()
+	})
+	bad := `{"channels": [{"id": "test_channel", "type": "float32"}, {"id": "test_other", "type": "float128"}]}`
+	if err := LoadEASportsWRCChannels(strings.NewReader(bad)); err == nil {
+		t.Error("unknown type: no error")
+	}
+	if err := LoadEASportsWRCChannels(strings.NewReader(`{"channels": [`)); err == nil {
+		t.Error("bad json: no error")
+	}
+	easportsWRCChannelsMu.RLock()
+	_, ok := easportsWRCChannels["test_channel"]
+	easportsWRCChannelsMu.RUnlock()
+	if ok {
+		t.Error("channels of an invalid file applied")
+	}
+
+	good := `{"channels": [{"id": "test_channel", "type": "uint16"}]}`
+	if err := LoadEASportsWRCChannels(strings.NewReader(good)); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadEASportsWRCStructure(strings.NewReader(`{"id": "test", "packets": [{"id": "session_update", "4cc": "test",
+		"header": {"channels": ["packet_4cc"]}, "channels": ["test_channel"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PacketEASportsWRCCustom{Structure: s, Values: map[string]float64{}}
+	if err := p.UnmarshalBinary([]byte("test\x2a\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := p.Value("test_channel"); v != 42 {
+		t.Errorf("test_channel = %v, want 42", v)
+	}
+	if err := p.UnmarshalBinary([]byte("test\x2a")); err == nil {
+		t.Error("size mismatch: no error")
+	}
+}
+
+// A layout without four-CC does not take packets of the other formats.
+func TestEASportsWRCStructureSizeOnly(t *testing.T) {
+	channels := `"vehicle_throttle", "vehicle_steering"` + strings.Repeat(`, "vehicle_speed"`, PacketDirtSeriesExtraData3Length/4-2)
+	s, err := LoadEASportsWRCStructure(strings.NewReader(`{"id": "test", "packets": [{"id": "custom", "channels": [` + channels + `]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirt := dirtSeriesPacket(t, 3)
+	if _, score := s.detect(dirt); score >= detectDirtSeries(dirt) {
+		t.Errorf("dirt packet: score %d, dirt %d", score, detectDirtSeries(dirt))
+	}
+	b := make([]byte, PacketDirtSeriesExtraData3Length)
+	if _, score := s.detect(b); score < MinConfidence {
+		t.Errorf("packet rejected by the other formats: score %d", score)
+	}
+	b[0], b[1], b[2], b[3] = 0, 0, 0x80, 0x7f // throttle +Inf
+	if _, score := s.detect(b); score != 0 {
+		t.Errorf("implausible throttle: score %d", score)
+	}
+}
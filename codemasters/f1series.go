@@ -0,0 +1,497 @@
+package codemasters
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	PacketF1HeaderLength = 29
+	F1NumCars            = 22
+	F1NoCar              = 255 // PlayerCarIndex while spectating, SecondaryPlayerCarIndex without a second player
+)
+
+// F1 series packet ids (PacketF1Header.PacketId)
+const (
+	F1PacketMotion       = 0
+	F1PacketSession      = 1
+	F1PacketLapData      = 2
+	F1PacketEvent        = 3
+	F1PacketParticipants = 4
+	F1PacketCarSetups    = 5
+	F1PacketCarTelemetry = 6
+	F1PacketCarStatus    = 7
+)
+
+// per car record sizes
+const (
+	F1CarMotionDataLength    = 60
+	F1CarTelemetryDataLength = 60
+	F1CarStatusDataLength    = 55
+	F1LapDataLength23        = 50 // F1 23
+	F1LapDataLength24        = 57 // F1 24
+	F1SessionDataLength      = 17 // leading part used by this package
+)
+
+func init() {
+	Register(FormatSpec{
+		Format: FormatF1Series,
+		Detect: detectF1Series,
+		New:    func() Packet { return &PacketF1Series{CarIndex: -1} },
+	})
+}
+
+// detectF1Series checks the header magic (packet format and game year).
+func detectF1Series(b []byte) int {
+	if !IsPacketF1Series(b) || b[6] > 15 { // packet id
+		return 0
+	}
+	return 100
+}
+
+// IsPacketF1Series reports whether b starts with an F1 23/24 packet header.
+func IsPacketF1Series(b []byte) bool {
+	if len(b) < PacketF1HeaderLength {
+		return false
+	}
+	format := binary.LittleEndian.Uint16(b[0:2])
+	switch format {
+	case 2023, 2024:
+		return int(b[2]) == int(format%100)
+	}
+	return false
+}
+
+type PacketF1Header struct {
+	PacketFormat            uint16 // 2023, 2024
+	GameYear                uint8  // 23, 24
+	GameMajorVersion        uint8
+	GameMinorVersion        uint8
+	PacketVersion           uint8
+	PacketId                uint8
+	SessionUid              uint64
+	SessionTime             float32
+	FrameIdentifier         uint32
+	OverallFrameIdentifier  uint32
+	PlayerCarIndex          uint8
+	SecondaryPlayerCarIndex uint8 // 255 if no second player
+}
+
+func (p *PacketF1Header) UnmarshalBinary(b []byte) error {
+	if len(b) < PacketF1HeaderLength {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.PacketFormat = binary.LittleEndian.Uint16(b[0:2])
+	p.GameYear = b[2]
+	p.GameMajorVersion = b[3]
+	p.GameMinorVersion = b[4]
+	p.PacketVersion = b[5]
+	p.PacketId = b[6]
+	p.SessionUid = binary.LittleEndian.Uint64(b[7:15])
+	p.SessionTime = math.Float32frombits(binary.LittleEndian.Uint32(b[15:19]))
+	p.FrameIdentifier = binary.LittleEndian.Uint32(b[19:23])
+	p.OverallFrameIdentifier = binary.LittleEndian.Uint32(b[23:27])
+	p.PlayerCarIndex = b[27]
+	p.SecondaryPlayerCarIndex = b[28]
+	return nil
+}
+
+type F1CarMotionData struct {
+	WorldPositionX     float32 // World space position
+	WorldPositionY     float32 // World space position
+	WorldPositionZ     float32 // World space position
+	WorldVelocityX     float32 // Velocity in world space
+	WorldVelocityY     float32 // Velocity in world space
+	WorldVelocityZ     float32 // Velocity in world space
+	WorldForwardDirX   int16   // World space forward direction (normalised by 32767)
+	WorldForwardDirY   int16   // World space forward direction (normalised by 32767)
+	WorldForwardDirZ   int16   // World space forward direction (normalised by 32767)
+	WorldRightDirX     int16   // World space right direction (normalised by 32767)
+	WorldRightDirY     int16   // World space right direction (normalised by 32767)
+	WorldRightDirZ     int16   // World space right direction (normalised by 32767)
+	GForceLateral      float32
+	GForceLongitudinal float32
+	GForceVertical     float32
+	Yaw                float32 // radians
+	Pitch              float32 // radians
+	Roll               float32 // radians
+}
+
+func (p *F1CarMotionData) UnmarshalBinary(b []byte) error {
+	if len(b) < F1CarMotionDataLength {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.WorldPositionX = math.Float32frombits(binary.LittleEndian.Uint32(b[0:4]))
+	p.WorldPositionY = math.Float32frombits(binary.LittleEndian.Uint32(b[4:8]))
+	p.WorldPositionZ = math.Float32frombits(binary.LittleEndian.Uint32(b[8:12]))
+	p.WorldVelocityX = math.Float32frombits(binary.LittleEndian.Uint32(b[12:16]))
+	p.WorldVelocityY = math.Float32frombits(binary.LittleEndian.Uint32(b[16:20]))
+	p.WorldVelocityZ = math.Float32frombits(binary.LittleEndian.Uint32(b[20:24]))
+	p.WorldForwardDirX = int16(binary.LittleEndian.Uint16(b[24:26]))
+	p.WorldForwardDirY = int16(binary.LittleEndian.Uint16(b[26:28]))
+	p.WorldForwardDirZ = int16(binary.LittleEndian.Uint16(b[28:30]))
+	p.WorldRightDirX = int16(binary.LittleEndian.Uint16(b[30:32]))
+	p.WorldRightDirY = int16(binary.LittleEndian.Uint16(b[32:34]))
+	p.WorldRightDirZ = int16(binary.LittleEndian.Uint16(b[34:36]))
+	p.GForceLateral = math.Float32frombits(binary.LittleEndian.Uint32(b[36:40]))
+	p.GForceLongitudinal = math.Float32frombits(binary.LittleEndian.Uint32(b[40:44]))
+	p.GForceVertical = math.Float32frombits(binary.LittleEndian.Uint32(b[44:48]))
+	p.Yaw = math.Float32frombits(binary.LittleEndian.Uint32(b[48:52]))
+	p.Pitch = math.Float32frombits(binary.LittleEndian.Uint32(b[52:56]))
+	p.Roll = math.Float32frombits(binary.LittleEndian.Uint32(b[56:60]))
+	return nil
+}
+
+type F1CarTelemetryData struct {
+	Speed                   uint16    // km/h
+	Throttle                float32   // 0.0 - 1.0
+	Steer                   float32   // -1.0 (full lock left) - 1.0 (full lock right)
+	Brake                   float32   // 0.0 - 1.0
+	Clutch                  uint8     // 0 - 100
+	Gear                    int8      // 1-8, N=0, R=-1
+	EngineRpm               uint16    //
+	Drs                     uint8     // 0 = off, 1 = on
+	RevLightsPercent        uint8     //
+	RevLightsBitValue       uint16    // bit 0 = leftmost LED, bit 14 = rightmost LED
+	BrakesTemperature       [4]uint16 // RL, RR, FL, FR (centigrade)
+	TyresSurfaceTemperature [4]uint8  // RL, RR, FL, FR (centigrade)
+	TyresInnerTemperature   [4]uint8  // RL, RR, FL, FR (centigrade)
+	EngineTemperature       uint16    // centigrade
+	TyresPressure           [4]float32
+	SurfaceType             [4]uint8
+}
+
+func (p *F1CarTelemetryData) UnmarshalBinary(b []byte) error {
+	if len(b) < F1CarTelemetryDataLength {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.Speed = binary.LittleEndian.Uint16(b[0:2])
+	p.Throttle = math.Float32frombits(binary.LittleEndian.Uint32(b[2:6]))
+	p.Steer = math.Float32frombits(binary.LittleEndian.Uint32(b[6:10]))
+	p.Brake = math.Float32frombits(binary.LittleEndian.Uint32(b[10:14]))
+	p.Clutch = b[14]
+	p.Gear = int8(b[15])
+	p.EngineRpm = binary.LittleEndian.Uint16(b[16:18])
+	p.Drs = b[18]
+	p.RevLightsPercent = b[19]
+	p.RevLightsBitValue = binary.LittleEndian.Uint16(b[20:22])
+	for i := 0; i < 4; i++ {
+		p.BrakesTemperature[i] = binary.LittleEndian.Uint16(b[22+2*i : 24+2*i])
+		p.TyresSurfaceTemperature[i] = b[30+i]
+		p.TyresInnerTemperature[i] = b[34+i]
+		p.TyresPressure[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[40+4*i : 44+4*i]))
+		p.SurfaceType[i] = b[56+i]
+	}
+	p.EngineTemperature = binary.LittleEndian.Uint16(b[38:40])
+	return nil
+}
+
+type F1LapData struct {
+	LastLapTimeInMS    uint32
+	CurrentLapTimeInMS uint32
+	Sector1TimeInMS    uint16
+	Sector1TimeMinutes uint8
+	Sector2TimeInMS    uint16
+	Sector2TimeMinutes uint8
+	LapDistance        float32 // meters
+	TotalDistance      float32 // meters
+	SafetyCarDelta     float32
+	CarPosition        uint8
+	CurrentLapNum      uint8
+	PitStatus          uint8 // 0 = none, 1 = pitting, 2 = in pit area
+	NumPitStops        uint8
+	Sector             uint8 // 0 = sector1, 1 = sector2, 2 = sector3
+	CurrentLapInvalid  uint8
+	Penalties          uint8 // seconds
+}
+
+// UnmarshalBinary decodes the F1 23 layout, see unmarshalFormat for F1 24.
+func (p *F1LapData) UnmarshalBinary(b []byte) error {
+	return p.unmarshalFormat(b, 2023)
+}
+
+func (p *F1LapData) unmarshalFormat(b []byte, format uint16) error {
+	size, shift := F1LapDataLength23, 0
+	if format >= 2024 { // delta minutes parts inserted before lapDistance
+		size, shift = F1LapDataLength24, 2
+	}
+	if len(b) < size {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.LastLapTimeInMS = binary.LittleEndian.Uint32(b[0:4])
+	p.CurrentLapTimeInMS = binary.LittleEndian.Uint32(b[4:8])
+	p.Sector1TimeInMS = binary.LittleEndian.Uint16(b[8:10])
+	p.Sector1TimeMinutes = b[10]
+	p.Sector2TimeInMS = binary.LittleEndian.Uint16(b[11:13])
+	p.Sector2TimeMinutes = b[13]
+	b = b[shift:]
+	p.LapDistance = math.Float32frombits(binary.LittleEndian.Uint32(b[18:22]))
+	p.TotalDistance = math.Float32frombits(binary.LittleEndian.Uint32(b[22:26]))
+	p.SafetyCarDelta = math.Float32frombits(binary.LittleEndian.Uint32(b[26:30]))
+	p.CarPosition = b[30]
+	p.CurrentLapNum = b[31]
+	p.PitStatus = b[32]
+	p.NumPitStops = b[33]
+	p.Sector = b[34]
+	p.CurrentLapInvalid = b[35]
+	p.Penalties = b[36]
+	return nil
+}
+
+type F1CarStatusData struct {
+	TractionControl       uint8 // 0 = off, 1 = medium, 2 = full
+	AntiLockBrakes        uint8 // 0 = off, 1 = on
+	FuelMix               uint8
+	FrontBrakeBias        uint8 // percentage
+	PitLimiterStatus      uint8 // 0 = off, 1 = on
+	FuelInTank            float32
+	FuelCapacity          float32
+	FuelRemainingLaps     float32
+	MaxRpm                uint16 // cars max RPM, point of rev limiter
+	IdleRpm               uint16
+	MaxGears              uint8
+	DrsAllowed            uint8 // 0 = not allowed, 1 = allowed
+	DrsActivationDistance uint16
+	ActualTyreCompound    uint8
+	VisualTyreCompound    uint8
+	TyresAgeLaps          uint8
+	VehicleFiaFlags       int8 // -1 = invalid/unknown, 0 = none, 1 = green, 2 = blue, 3 = yellow
+}
+
+func (p *F1CarStatusData) UnmarshalBinary(b []byte) error {
+	if len(b) < F1CarStatusDataLength {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.TractionControl = b[0]
+	p.AntiLockBrakes = b[1]
+	p.FuelMix = b[2]
+	p.FrontBrakeBias = b[3]
+	p.PitLimiterStatus = b[4]
+	p.FuelInTank = math.Float32frombits(binary.LittleEndian.Uint32(b[5:9]))
+	p.FuelCapacity = math.Float32frombits(binary.LittleEndian.Uint32(b[9:13]))
+	p.FuelRemainingLaps = math.Float32frombits(binary.LittleEndian.Uint32(b[13:17]))
+	p.MaxRpm = binary.LittleEndian.Uint16(b[17:19])
+	p.IdleRpm = binary.LittleEndian.Uint16(b[19:21])
+	p.MaxGears = b[21]
+	p.DrsAllowed = b[22]
+	p.DrsActivationDistance = binary.LittleEndian.Uint16(b[23:25])
+	p.ActualTyreCompound = b[25]
+	p.VisualTyreCompound = b[26]
+	p.TyresAgeLaps = b[27]
+	p.VehicleFiaFlags = int8(b[28])
+	return nil
+}
+
+type F1SessionData struct {
+	Weather           uint8 // 0 = clear, 1 = light cloud, 2 = overcast, 3 = light rain, 4 = heavy rain, 5 = storm
+	TrackTemperature  int8  // centigrade
+	AirTemperature    int8  // centigrade
+	TotalLaps         uint8
+	TrackLength       uint16 // meters
+	SessionType       uint8
+	TrackId           int8 // -1 for unknown
+	Formula           uint8
+	SessionTimeLeft   uint16 // seconds
+	SessionDuration   uint16 // seconds
+	PitSpeedLimit     uint8  // km/h
+	GamePaused        uint8
+	IsSpectating      uint8
+	SpectatorCarIndex uint8
+}
+
+func (p *F1SessionData) UnmarshalBinary(b []byte) error {
+	if len(b) < F1SessionDataLength {
+		return fmt.Errorf("invalid packet size: %d", len(b))
+	}
+	p.Weather = b[0]
+	p.TrackTemperature = int8(b[1])
+	p.AirTemperature = int8(b[2])
+	p.TotalLaps = b[3]
+	p.TrackLength = binary.LittleEndian.Uint16(b[4:6])
+	p.SessionType = b[6]
+	p.TrackId = int8(b[7])
+	p.Formula = b[8]
+	p.SessionTimeLeft = binary.LittleEndian.Uint16(b[9:11])
+	p.SessionDuration = binary.LittleEndian.Uint16(b[11:13])
+	p.PitSpeedLimit = b[13]
+	p.GamePaused = b[14]
+	p.IsSpectating = b[15]
+	p.SpectatorCarIndex = b[16]
+	return nil
+}
+
+// PacketF1Series accumulates the F1 packet family for a single car.
+// Each UnmarshalBinary call merges one packet into the current state.
+type PacketF1Series struct {
+	CarIndex     int // car to follow, negative selects Header.PlayerCarIndex
+	Header       PacketF1Header
+	CarMotion    F1CarMotionData
+	CarTelemetry F1CarTelemetryData
+	LapData      F1LapData
+	CarStatus    F1CarStatusData
+	Session      F1SessionData
+	received     uint32 // bit set of received packet ids
+}
+
+func (p *PacketF1Series) UnmarshalBinary(b []byte) error {
+	if !IsPacketF1Series(b) {
+		return fmt.Errorf("invalid packet header")
+	}
+	if err := p.Header.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	body := b[PacketF1HeaderLength:]
+	id := p.Header.PacketId
+	if id == F1PacketSession {
+		if err := p.Session.UnmarshalBinary(body); err != nil {
+			return err
+		}
+		p.received |= 1 << id
+		return nil
+	}
+	idx := p.Car()
+	if idx < 0 {
+		return nil // spectating without a followed car, keep the last state
+	}
+	if idx >= F1NumCars {
+		return fmt.Errorf("invalid car index: %d", idx)
+	}
+	var err error
+	switch id {
+	case F1PacketMotion:
+		err = p.CarMotion.UnmarshalBinary(carData(body, idx, F1CarMotionDataLength))
+	case F1PacketLapData:
+		size := F1LapDataLength23
+		if p.Header.PacketFormat >= 2024 {
+			size = F1LapDataLength24
+		}
+		err = p.LapData.unmarshalFormat(carData(body, idx, size), p.Header.PacketFormat)
+	case F1PacketCarTelemetry:
+		err = p.CarTelemetry.UnmarshalBinary(carData(body, idx, F1CarTelemetryDataLength))
+	case F1PacketCarStatus:
+		err = p.CarStatus.UnmarshalBinary(carData(body, idx, F1CarStatusDataLength))
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p.received |= 1 << id
+	return nil
+}
+
+// Car returns the index of the car this packet reports: CarIndex, the player car,
+// or the spectated car while spectating. It is negative if there is none.
+func (p *PacketF1Series) Car() int {
+	if p.CarIndex >= 0 {
+		return p.CarIndex
+	}
+	if p.Header.PlayerCarIndex != F1NoCar {
+		return int(p.Header.PlayerCarIndex)
+	}
+	if p.Session.IsSpectating != 0 && p.Session.SpectatorCarIndex != F1NoCar {
+		return int(p.Session.SpectatorCarIndex)
+	}
+	return -1
+}
+
+// carData returns the record of car idx, or a short slice when body is truncated.
+func carData(body []byte, idx, size int) []byte {
+	if len(body) < (idx+1)*size {
+		return nil
+	}
+	return body[idx*size : (idx+1)*size]
+}
+
+func (p *PacketF1Series) Format() Format {
+	return FormatF1Series
+}
+
+func (p *PacketF1Series) Frame(f *Frame) {
+	*f = Frame{Format: FormatF1Series}
+	if p.received&(1<<F1PacketCarTelemetry) != 0 {
+		t := &p.CarTelemetry
+		f.Available |= ChannelInputs | ChannelEngine | ChannelSpeed | ChannelBrakeTemperature
+		f.Steering = t.Steer
+		f.Throttle = t.Throttle
+		f.Brake = t.Brake
+		f.Clutch = p.Clutch()
+		f.Gear = p.Gear()
+		f.RPM = float32(t.EngineRpm)
+		f.Speed = p.Speed()
+		f.BrakeTemperature = Wheels{
+			float32(t.BrakesTemperature[2]), float32(t.BrakesTemperature[3]),
+			float32(t.BrakesTemperature[0]), float32(t.BrakesTemperature[1]),
+		}
+	}
+	if p.received&(1<<F1PacketCarStatus) != 0 {
+		f.MaxRPM = float32(p.CarStatus.MaxRpm)
+		f.IdleRPM = float32(p.CarStatus.IdleRpm)
+		f.MaxGear = p.MaxGear()
+	}
+	if p.received&(1<<F1PacketMotion) != 0 {
+		m := &p.CarMotion
+		f.Available |= ChannelPosition | ChannelVelocity | ChannelOrientation | ChannelGForce
+		f.Position = Vec3{m.WorldPositionX, m.WorldPositionY, m.WorldPositionZ}
+		f.Velocity = Vec3{m.WorldVelocityX, m.WorldVelocityY, m.WorldVelocityZ}
+		f.Forward = Vec3{float32(m.WorldForwardDirX), float32(m.WorldForwardDirY), float32(m.WorldForwardDirZ)}.Scale(1.0 / 32767)
+		f.Right = Vec3{float32(m.WorldRightDirX), float32(m.WorldRightDirY), float32(m.WorldRightDirZ)}.Scale(1.0 / 32767)
+		f.Up = f.Forward.Cross(f.Right)
+		f.GForceLateral = m.GForceLateral
+		f.GForceLongitudinal = m.GForceLongitudinal
+	}
+	if p.received&(1<<F1PacketLapData) != 0 {
+		l := &p.LapData
+		f.Available |= ChannelLap
+		f.Lap = int(l.CurrentLapNum) - 1
+		f.LapTime = float32(l.CurrentLapTimeInMS) / 1000
+		f.LapDistance = l.LapDistance
+		f.setStage(f.LapTime, l.LapDistance, float32(p.Session.TrackLength))
+	}
+}
+
+func (p *PacketF1Series) Steering() float32 {
+	return p.CarTelemetry.Steer
+}
+
+func (p *PacketF1Series) Throttle() float32 {
+	return p.CarTelemetry.Throttle
+}
+
+func (p *PacketF1Series) Brake() float32 {
+	return p.CarTelemetry.Brake
+}
+
+func (p *PacketF1Series) Clutch() float32 {
+	return float32(p.CarTelemetry.Clutch) / 100
+}
+
+func (p *PacketF1Series) Handbrake() float32 {
+	return 0
+}
+
+func (p *PacketF1Series) Gear() int {
+	return int(p.CarTelemetry.Gear)
+}
+
+func (p *PacketF1Series) MaxGear() int {
+	return int(p.CarStatus.MaxGears)
+}
+
+func (p *PacketF1Series) RPM() float32 {
+	return float32(p.CarTelemetry.EngineRpm)
+}
+
+func (p *PacketF1Series) MaxRPM() float32 {
+	return float32(p.CarStatus.MaxRpm)
+}
+
+// Speed returns m/s like the other titles, the game sends km/h.
+func (p *PacketF1Series) Speed() float32 {
+	return float32(p.CarTelemetry.Speed) / 3.6
+}
+
+func (p *PacketF1Series) StageDistance() float32 {
+	return float32(p.Session.TrackLength)
+}
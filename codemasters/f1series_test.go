@@ -0,0 +1,54 @@
+package codemasters
+
+import "testing"
+
+// f1SessionPacket returns an F1 24 session packet spectating car spectator.
+func f1SessionPacket(spectator uint8) []byte {
+	b := make([]byte, PacketF1HeaderLength+F1SessionDataLength)
+	copy(b, f1SeriesPacket()[:PacketF1HeaderLength])
+	b[6] = F1PacketSession
+	b[27] = F1NoCar
+	b[PacketF1HeaderLength+15] = 1
+	b[PacketF1HeaderLength+16] = spectator
+	return b
+}
+
+func TestF1SeriesSpectating(t *testing.T) {
+	telemetry := f1SeriesPacket()
+	telemetry[27] = F1NoCar
+
+	p := &PacketF1Series{CarIndex: -1}
+	if err := p.UnmarshalBinary(telemetry); err != nil {
+		t.Fatalf("no followed car: %v", err)
+	}
+	var f Frame
+	p.Frame(&f)
+	if f.Available != 0 {
+		t.Errorf("no followed car: available %v", f.Available)
+	}
+
+	for _, b := range [][]byte{f1SessionPacket(0), telemetry} {
+		if err := p.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Car() != 0 {
+		t.Errorf("car %d, want the spectated car 0", p.Car())
+	}
+	p.Frame(&f)
+	if f.Available&ChannelInputs == 0 || f.Speed == 0 {
+		t.Errorf("spectated car: available %v speed %v", f.Available, f.Speed)
+	}
+}
+
+func TestF1SeriesReceivedOnSuccess(t *testing.T) {
+	p := &PacketF1Series{CarIndex: -1}
+	if err := p.UnmarshalBinary(f1SeriesPacket()[:PacketF1HeaderLength+10]); err == nil {
+		t.Fatal("truncated packet: no error")
+	}
+	var f Frame
+	p.Frame(&f)
+	if f.Available != 0 {
+		t.Errorf("truncated packet: available %v", f.Available)
+	}
+}
@@ -0,0 +1,132 @@
+package codemasters
+
+// Channel flags the channel groups present in a Frame.
+type Channel uint32
+
+const (
+	ChannelInputs           Channel = 1 << iota // Steering, Throttle, Brake, Clutch
+	ChannelHandbrake                            // Handbrake
+	ChannelEngine                               // Gear, MaxGear, RPM, MaxRPM, IdleRPM
+	ChannelSpeed                                // Speed
+	ChannelPosition                             // Position
+	ChannelVelocity                             // Velocity
+	ChannelAcceleration                         // Acceleration
+	ChannelOrientation                          // Forward, Right, Up
+	ChannelGForce                               // GForceLateral, GForceLongitudinal
+	ChannelSuspension                           // SuspensionPosition, SuspensionVelocity
+	ChannelWheelSpeed                           // WheelSpeed
+	ChannelBrakeTemperature                     // BrakeTemperature
+	ChannelLap                                  // Lap, LapTime, LapDistance
+	ChannelStage                                // StageTime, StageDistance, StageLength, StageProgress
+)
+
+const standardGravity = 9.80665
+
+type Vec3 struct {
+	X, Y, Z float32
+}
+
+func (v Vec3) Scale(s float32) Vec3 {
+	return Vec3{v.X * s, v.Y * s, v.Z * s}
+}
+
+func (v Vec3) Dot(w Vec3) float32 {
+	return v.X*w.X + v.Y*w.Y + v.Z*w.Z
+}
+
+func (v Vec3) Cross(w Vec3) Vec3 {
+	return Vec3{v.Y*w.Z - v.Z*w.Y, v.Z*w.X - v.X*w.Z, v.X*w.Y - v.Y*w.X}
+}
+
+type Wheels struct {
+	FL, FR, RL, RR float32
+}
+
+// Frame is telemetry normalized across games.
+//
+// The world is left-handed with Y up, as sent by the Codemasters games.
+// Units are SI: meters, seconds, m/s, m/s², celsius; G-forces in G.
+// Channel groups missing from Available were not sent by the game
+// and hold zero values.
+type Frame struct {
+	Format    Format
+	Available Channel
+
+	Steering  float32 // -1 (left) - 1 (right)
+	Throttle  float32 // 0 - 1
+	Brake     float32 // 0 - 1
+	Clutch    float32 // 0 - 1
+	Handbrake float32 // 0 - 1
+	Gear      int     // -1 = reverse, 0 = neutral, 1.. forward
+	MaxGear   int     // number of forward gears, 0 if unknown
+	RPM       float32
+	MaxRPM    float32
+	IdleRPM   float32
+	Speed     float32 // m/s
+
+	Position           Vec3 // m, world space
+	Velocity           Vec3 // m/s, world space
+	Acceleration       Vec3 // m/s², world space
+	Forward            Vec3 // unit vector, world space
+	Right              Vec3 // unit vector, world space
+	Up                 Vec3 // unit vector, world space
+	GForceLateral      float32
+	GForceLongitudinal float32
+
+	SuspensionPosition Wheels // m
+	SuspensionVelocity Wheels // m/s
+	WheelSpeed         Wheels // m/s
+	BrakeTemperature   Wheels // celsius
+
+	Lap           int     // current lap, 0 based
+	LapTime       float32 // s
+	LapDistance   float32 // m
+	StageTime     float32 // s
+	StageDistance float32 // m driven on the stage
+	StageLength   float32 // m
+	StageProgress float32 // 0 - 1, StageDistance / StageLength
+}
+
+// normalizeGear maps a gear index with game specific neutral and reverse
+// indices to -1 = reverse, 0 = neutral, 1.. forward.
+// Negative neutral or reverse indices do not take a slot among the forward gears.
+func normalizeGear(idx, neutral, reverse int) int {
+	switch idx {
+	case reverse:
+		return -1
+	case neutral:
+		return 0
+	}
+	g := idx + 1
+	if 0 <= neutral && neutral < idx {
+		g--
+	}
+	if 0 <= reverse && reverse < idx {
+		g--
+	}
+	return g
+}
+
+// Has reports whether all channels in c are available.
+func (f *Frame) Has(c Channel) bool {
+	return f.Available&c == c
+}
+
+// setStage fills the stage channels and derives StageProgress.
+func (f *Frame) setStage(t, distance, length float32) {
+	f.Available |= ChannelStage
+	f.StageTime = t
+	f.StageDistance = distance
+	f.StageLength = length
+	f.StageProgress = 0
+	if length > 0 {
+		f.StageProgress = distance / length
+	}
+}
+
+// setGForce derives G-forces from a world space acceleration.
+func (f *Frame) setGForce(acc Vec3) {
+	f.Available |= ChannelGForce
+	f.GForceLateral = acc.Dot(f.Right) / standardGravity
+	f.GForceLongitudinal = acc.Dot(f.Forward) / standardGravity
+}
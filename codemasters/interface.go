@@ -1,31 +1,76 @@
 package codemasters
 
 type Telemetry interface {
+	Format() Format
 	Steering() float32
 	Throttle() float32
 	Brake() float32
 	Clutch() float32
 	Handbrake() float32
-	Gear() int
+	Gear() int    // -1 = reverse, 0 = neutral, 1.. forward
+	MaxGear() int // number of forward gears, 0 if unknown
 	RPM() float32
 	MaxRPM() float32
 	Speed() float32
 	StageDistance() float32
+	Frame(f *Frame) // fills f with the normalized telemetry
 }
 
-func Decode(b []byte) (Telemetry, error) {
-	switch len(b) {
-	case PacketEASportsWRCLength:
-		pkt := &PacketEASportsWRC{}
-		if err := pkt.UnmarshalBinary(b); err != nil {
-			return nil, err
-		}
-		return pkt, nil
-	default:
-		pkt := &PacketDirtSeries{}
-		if err := pkt.UnmarshalBinary(b); err != nil {
-			return nil, err
-		}
-		return pkt, nil
+// Decode detects the format of b and decodes it into a new packet.
+// Formats spreading telemetry over several packets (F1 series, EA Sports WRC
+// sessions) need a Decoder to report more than the packet b.
+func Decode(b []byte) (Telemetry, Format, error) {
+	spec, _, err := Detect(b)
+	if err != nil {
+		return nil, FormatUnknown, err
 	}
+	pkt := spec.New()
+	if err := pkt.UnmarshalBinary(b); err != nil {
+		return nil, spec.Format, err
+	}
+	return pkt, spec.Format, nil
+}
+
+// Decoder keeps one packet per format between calls, so formats which
+// spread telemetry over several packets (F1 series, EA Sports WRC sessions)
+// accumulate state.
+type Decoder struct {
+	Index   int // F1 series car index, negative selects the player car
+	packets map[Format]Packet
+}
+
+func NewDecoder(index int) *Decoder {
+	return &Decoder{Index: index, packets: map[Format]Packet{}}
+}
+
+// Decode returns telemetry owned by d, valid until the next call.
+// Packets are reused per format, so decoding does not allocate once
+// every format has been seen.
+func (d *Decoder) Decode(b []byte) (Telemetry, Format, error) {
+	spec, _, err := Detect(b)
+	if err != nil {
+		return nil, FormatUnknown, err
+	}
+	pkt, ok := d.packets[spec.Format]
+	if !ok {
+		pkt = spec.New()
+		d.packets[spec.Format] = pkt
+	}
+	if f1, ok := pkt.(*PacketF1Series); ok {
+		f1.CarIndex = d.Index
+	}
+	if err := pkt.UnmarshalBinary(b); err != nil {
+		return nil, spec.Format, err
+	}
+	return pkt, spec.Format, nil
+}
+
+// DecodeFrame decodes b and fills the caller owned f without allocating.
+func (d *Decoder) DecodeFrame(b []byte, f *Frame) (Format, error) {
+	pkt, format, err := d.Decode(b)
+	if err != nil {
+		return format, err
+	}
+	pkt.Frame(f)
+	return format, nil
 }
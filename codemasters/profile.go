@@ -0,0 +1,107 @@
+package codemasters
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Profile holds the per game adjustments applied to raw telemetry.
+type Profile struct {
+	SteeringSign float32 // 1, -1, or 0 for SteeringAuto
+	ReverseGear  int     // gear value meaning reverse for titles the decoder does not know, reported as -1
+	SpeedScale   float32 // multiplier from m/s to the displayed unit
+}
+
+// SteeringAuto inverts the steering of DiRT series packets carrying the stage
+// channel (extradata 2 and up) without a track size: WRC Generations sends the
+// DiRT format with inverted steering and no track size, but so do the DiRT games
+// in free roam, set the sign of the game to override it.
+// Packets without the stage channel (extradata 0 and 1) are never inverted.
+const SteeringAuto = 0
+
+var DefaultProfile = Profile{SteeringSign: SteeringAuto, ReverseGear: -1, SpeedScale: 1}
+
+var speedUnits = map[string]float32{
+	"ms":  1,
+	"kmh": 3.6,
+	"mph": 2.236936,
+}
+
+// Apply normalizes the steering sign and the reverse gear of f.
+// SpeedScale is left to the display, f.Speed stays in m/s.
+func (p Profile) Apply(f *Frame) {
+	sign := p.SteeringSign
+	if sign == SteeringAuto {
+		sign = 1
+		if f.Format == FormatDirtSeries && f.Has(ChannelStage) && f.StageLength == 0 {
+			sign = -1
+		}
+	}
+	f.Steering *= sign
+	if f.Gear == p.ReverseGear {
+		f.Gear = -1
+	}
+}
+
+// Profiles maps formats to profiles, missing formats use DefaultProfile.
+//
+// The text form is a comma separated list of "format:key=value;key=value"
+// with keys steer (1, -1 or auto), reverse (raw gear) and speed (ms, kmh, mph),
+// e.g. "dirt:steer=-1;reverse=10,easportswrc:speed=kmh".
+type Profiles map[Format]Profile
+
+func (ps Profiles) Get(f Format) Profile {
+	if p, ok := ps[f]; ok {
+		return p
+	}
+	return DefaultProfile
+}
+
+func (ps *Profiles) UnmarshalText(text []byte) error {
+	res := Profiles{}
+	for _, entry := range strings.Split(string(text), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, opts, ok := strings.Cut(entry, ":")
+		if !ok {
+			return fmt.Errorf("invalid profile: %q", entry)
+		}
+		f := Format(name)
+		p := res.Get(f)
+		for _, opt := range strings.Split(opts, ";") {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "steer":
+				if value == "auto" {
+					p.SteeringSign = SteeringAuto
+					continue
+				}
+				v, err := strconv.ParseFloat(value, 32)
+				if err != nil {
+					return fmt.Errorf("invalid profile %q: %w", entry, err)
+				}
+				p.SteeringSign = float32(v)
+			case "reverse":
+				v, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("invalid profile %q: %w", entry, err)
+				}
+				p.ReverseGear = v
+			case "speed":
+				v, ok := speedUnits[value]
+				if !ok {
+					return fmt.Errorf("invalid profile %q: unknown speed unit %q", entry, value)
+				}
+				p.SpeedScale = v
+			default:
+				return fmt.Errorf("invalid profile %q: unknown key %q", entry, key)
+			}
+		}
+		res[f] = p
+	}
+	*ps = res
+	return nil
+}
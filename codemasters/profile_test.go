@@ -0,0 +1,43 @@
+package codemasters
+
+import "testing"
+
+func TestProfileSteering(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		profile string
+		frame   Frame
+		want    float32
+	}{
+		{"dirt stage", "", Frame{Format: FormatDirtSeries, Available: ChannelStage, Steering: 0.5, StageLength: 9000}, 0.5},
+		{"wrc generations", "", Frame{Format: FormatDirtSeries, Available: ChannelStage, Steering: 0.5}, -0.5},
+		{"dirt free roam", "dirt:steer=1", Frame{Format: FormatDirtSeries, Available: ChannelStage, Steering: 0.5}, 0.5},
+		{"dirt extradata 1", "", dirtSeriesFrame(t, 1), 0.5},
+		{"forced", "dirt:steer=-1", Frame{Format: FormatDirtSeries, Steering: 0.5, StageLength: 9000}, -0.5},
+		{"auto", "dirt:steer=-1;steer=auto", Frame{Format: FormatDirtSeries, Steering: 0.5, StageLength: 9000}, 0.5},
+		{"easportswrc", "", Frame{Format: FormatEASportsWRC, Steering: 0.5}, 0.5},
+		{"f1", "", Frame{Format: FormatF1Series, Steering: 0.5}, 0.5},
+	} {
+		var ps Profiles
+		if err := ps.UnmarshalText([]byte(c.profile)); err != nil {
+			t.Fatal(err)
+		}
+		f := c.frame
+		ps.Get(f.Format).Apply(&f)
+		if f.Steering != c.want {
+			t.Errorf("%s: steering %v, want %v", c.name, f.Steering, c.want)
+		}
+	}
+}
+
+// dirtSeriesFrame decodes the frame of a DiRT packet at extradata level.
+func dirtSeriesFrame(tb testing.TB, level int) Frame {
+	var p PacketDirtSeries
+	if err := p.UnmarshalBinary(dirtSeriesPacket(tb, level)); err != nil {
+		tb.Fatal(err)
+	}
+	p.VehicleSteering = 0.5
+	var f Frame
+	p.Frame(&f)
+	return f
+}
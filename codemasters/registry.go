@@ -0,0 +1,104 @@
+package codemasters
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Format identifies a packet layout.
+type Format string
+
+const (
+	FormatUnknown     Format = ""
+	FormatDirtSeries  Format = "dirt"
+	FormatEASportsWRC Format = "easportswrc"
+	FormatF1Series    Format = "f1"
+)
+
+// MinConfidence is the lowest detector score accepted by Decode.
+const MinConfidence = 50
+
+// Packet is a telemetry packet decodable from a UDP datagram.
+type Packet interface {
+	Telemetry
+	UnmarshalBinary(b []byte) error
+}
+
+// FormatSpec registers a packet format.
+// Detect returns a confidence score from 0 (not this format) to 100 (certain)
+// from cheap checks such as length, header magic, four-CC and plausible values.
+type FormatSpec struct {
+	Format Format
+	Detect func(b []byte) int
+	New    func() Packet
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []FormatSpec
+)
+
+// Register adds spec to the registry, replacing a spec of the same format.
+func Register(spec FormatSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i, s := range registry {
+		if s.Format == spec.Format {
+			registry[i] = spec
+			return
+		}
+	}
+	registry = append(registry, spec)
+}
+
+// Formats returns registered formats in registration order.
+func Formats() []Format {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	res := make([]Format, 0, len(registry))
+	for _, s := range registry {
+		res = append(res, s.Format)
+	}
+	return res
+}
+
+// Detect returns the spec with the highest confidence for b.
+func Detect(b []byte) (FormatSpec, int, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	best, score := FormatSpec{}, 0
+	for _, s := range registry {
+		if c := s.Detect(b); c > score {
+			best, score = s, c
+		}
+	}
+	if score < MinConfidence {
+		return FormatSpec{}, score, newUnknownFormatError(b)
+	}
+	return best, score, nil
+}
+
+// UnknownFormatError is returned for packets no registered format accepts.
+type UnknownFormatError struct {
+	Size int    // packet size
+	Head []byte // first bytes of the packet
+}
+
+func newUnknownFormatError(b []byte) *UnknownFormatError {
+	n := len(b)
+	if n > 16 {
+		n = 16
+	}
+	return &UnknownFormatError{Size: len(b), Head: append([]byte(nil), b[:n]...)}
+}
+
+func (e *UnknownFormatError) Error() string {
+	return fmt.Sprintf("unknown packet format: size=%d head=% x", e.Size, e.Head)
+}
+
+// plausible reports whether v is finite and within [min, max].
+func plausible(v, min, max float32) bool {
+	f := float64(v)
+	return !math.IsNaN(f) && f >= float64(min) && f <= float64(max)
+}
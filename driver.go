@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Driver is the state of one sender of a source, e.g. a sim rig on the LAN.
+type Driver struct {
+	Name   string
+	Addr   netip.AddrPort // last sender address
+	status Status
+	timer  *time.Timer
+	seq    sequencer
+	state  stateMachine  // guarded by the pipeline
+	hooked Params        // last published, guarded by the pipeline
+	stats  SequenceStats // guarded by the pipeline
+	since  time.Time     // first packet, the oldest active driver is the primary one
+}
+
+// Alias names the driver sending from Addr, any port if Port is 0.
+type Alias struct {
+	Name string
+	Addr netip.Addr
+	Port uint16
+}
+
+// Aliases is parsed from "name=ip[:port],...".
+type Aliases []Alias
+
+func (as *Aliases) UnmarshalText(text []byte) error {
+	res := Aliases{}
+	for _, entry := range strings.Split(string(text), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid driver alias: %q", entry)
+		}
+		a := Alias{Name: name}
+		if ap, err := netip.ParseAddrPort(addr); err == nil {
+			a.Addr, a.Port = ap.Addr().Unmap(), ap.Port()
+		} else if ip, err := netip.ParseAddr(addr); err == nil {
+			a.Addr = ip.Unmap()
+		} else {
+			return fmt.Errorf("invalid driver alias %q: %w", entry, err)
+		}
+		res = append(res, a)
+	}
+	*as = res
+	return nil
+}
+
+// Name returns the alias of from, "ip:port" if none matches.
+// An alias with a port takes precedence over one without.
+func (as Aliases) Name(from netip.AddrPort) string {
+	ip := from.Addr().Unmap()
+	name := ""
+	for _, a := range as {
+		if a.Addr != ip {
+			continue
+		}
+		if a.Port == from.Port() {
+			return a.Name
+		}
+		if a.Port == 0 && name == "" {
+			name = a.Name
+		}
+	}
+	if name == "" {
+		name = netip.AddrPortFrom(ip, from.Port()).String()
+	}
+	return name
+}
+
+// primary returns the oldest active driver, nil if none is active.
+// Overlays without ?driver= follow it, so interleaved senders don't flicker.
+func (pl *Pipeline) primary() *Driver {
+	var res *Driver
+	for _, d := range pl.drivers {
+		if d.status.Get().Active && (res == nil || d.since.Before(res.since)) {
+			res = d
+		}
+	}
+	return res
+}
+
+func (pl *Pipeline) active() bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.primary() != nil
+}
+
+// publish returns the params of d to publish, marked for overlays following the primary driver.
+func (pl *Pipeline) publish(d *Driver) Params {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	p := d.status.Get()
+	pd := pl.primary()
+	p.primary = pd == d || pd == nil
+	return p
+}
+
+type DriverStatus struct {
+	Source  string
+	Name    string
+	Addr    string
+	Active  bool
+	Paused  bool
+	State   SessionState
+	Primary bool
+	Format  codemasters.Format
+	Since   time.Time
+}
+
+func (pl *Pipeline) driverStatus() []DriverStatus {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pd := pl.primary()
+	res := []DriverStatus{}
+	for _, d := range pl.drivers {
+		p := d.status.Get()
+		res = append(res, DriverStatus{
+			Source:  pl.Name,
+			Name:    d.Name,
+			Addr:    d.Addr.String(),
+			Active:  p.Active,
+			Paused:  p.Paused,
+			State:   p.State,
+			Primary: d == pd,
+			Format:  p.Frame.Format,
+			Since:   d.since,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Since.Before(res[j].Since) })
+	return res
+}
+
+// serveDrivers lists the drivers of ?source=name, of all sources by default.
+func serveDrivers(w http.ResponseWriter, r *http.Request) {
+	res := []DriverStatus{}
+	for _, pl := range pipelines {
+		if name := r.URL.Query().Get("source"); name == "" || name == pl.Name {
+			res = append(res, pl.driverStatus()...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// demo car and course
+const (
+	demoLength   = 2000.0 // m, one lap of the looping course
+	demoIdleRPM  = 1000.0
+	demoMaxRPM   = 7500.0
+	demoShift    = 0.25 // s, clutch time of a gear change
+	demoGravity  = 9.80665
+	demoMaxGears = 6
+)
+
+// demoGearSpeed is the top speed in m/s of each forward gear.
+var demoGearSpeed = [demoMaxGears + 1]float64{0, 12, 20, 28, 36, 45, 58}
+
+// Generator is a Source of synthetic driving telemetry, e.g. to preview overlays without the game.
+// Packets are encoded in Format and decoded again like datagrams from the game.
+type Generator struct {
+	Format codemasters.Format // FormatDirtSeries or FormatEASportsWRC
+	Rate   float64            // packets per second
+
+	// car state
+	frame         uint64 // steps, the packet counter
+	time, lapTime float64
+	dist, speed   float64 // m along the course, m/s
+	lap, gear     int
+	shift         float64 // s left of the current gear change
+	throttle      float64
+	brake         float64
+	brakeTemp     float64
+}
+
+func NewGenerator(format codemasters.Format, rate float64) (*Generator, error) {
+	switch format {
+	case codemasters.FormatDirtSeries, codemasters.FormatEASportsWRC:
+	default:
+		return nil, fmt.Errorf("demo: unsupported format: %q", format)
+	}
+	if rate <= 0 || rate > 1000 {
+		return nil, fmt.Errorf("demo: invalid rate: %v", rate)
+	}
+	return &Generator{Format: format, Rate: rate, gear: 1, brakeTemp: 100}, nil
+}
+
+func (g *Generator) String() string {
+	return fmt.Sprintf("demo %s %vHz", g.Format, g.Rate)
+}
+
+func (g *Generator) Run(ctx context.Context, sink Sink) error {
+	dt := 1 / g.Rate
+	ticker := time.NewTicker(time.Duration(dt * float64(time.Second)))
+	defer ticker.Stop()
+	from := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t := <-ticker.C:
+			g.step(dt)
+			b, err := g.packet().MarshalBinary()
+			if err != nil {
+				return err
+			}
+			p := Packet{Time: t, Origin: from, Data: b}
+			sink.Decode(&p)
+			sink.Emit(&p)
+		}
+	}
+}
+
+// course returns the position and the unit forward vector at d m along the course,
+// an ellipse with wiggles and hills.
+func course(d float64) (pos, fwd [3]float64) {
+	at := func(d float64) [3]float64 {
+		a := 2 * math.Pi * d / demoLength
+		return [3]float64{
+			300*math.Cos(a) + 25*math.Sin(5*a),
+			8 * math.Sin(2*a),
+			180*math.Sin(a) + 25*math.Cos(3*a),
+		}
+	}
+	pos = at(d)
+	next := at(d + 1)
+	n := 0.0
+	for i := range fwd {
+		fwd[i] = next[i] - pos[i]
+		n += fwd[i] * fwd[i]
+	}
+	n = math.Sqrt(n)
+	for i := range fwd {
+		fwd[i] /= n
+	}
+	return pos, fwd
+}
+
+// heading returns the yaw of a forward vector.
+func heading(fwd [3]float64) float64 {
+	return math.Atan2(fwd[0], fwd[2])
+}
+
+// curvature returns the signed curvature (1/m) at d, positive turning right.
+func curvature(d float64) float64 {
+	_, f0 := course(d - 2)
+	_, f1 := course(d + 2)
+	h := heading(f1) - heading(f0)
+	h = math.Remainder(h, 2*math.Pi)
+	return h / 4
+}
+
+// step advances the car by dt seconds: the target speed drops before corners,
+// throttle and brake follow it and the gearbox shifts by speed.
+func (g *Generator) step(dt float64) {
+	k := 0.0 // sharpest curvature ahead
+	for ahead := 0.0; ahead <= 60; ahead += 10 {
+		k = math.Max(k, math.Abs(curvature(g.dist+ahead)))
+	}
+	target := math.Min(55, math.Sqrt(1.1*demoGravity/math.Max(k, 1e-4))) // grip limit
+	diff := target - g.speed
+	g.throttle = clamp(diff/4, 0, 1)
+	g.brake = clamp(-diff/6, 0, 1)
+	if g.shift > 0 {
+		g.shift -= dt
+		g.throttle = 0
+	}
+	acc := 7*g.throttle*(1-g.speed/60) - 11*g.brake - 0.0004*g.speed*g.speed
+	g.speed = math.Max(0, g.speed+acc*dt)
+	g.dist += g.speed * dt
+	g.frame++
+	g.time += dt
+	g.lapTime += dt
+	if g.dist >= demoLength {
+		g.dist -= demoLength
+		g.lap++
+		g.lapTime = 0
+	}
+	gear := g.gear // shift up near the top speed of a gear, down well below the one of the lower gear
+	if gear < demoMaxGears && g.speed > 0.95*demoGearSpeed[gear] {
+		gear++
+	} else if gear > 1 && g.speed < 0.7*demoGearSpeed[gear-1] {
+		gear--
+	}
+	if gear != g.gear {
+		g.gear = gear
+		g.shift = demoShift
+	}
+	g.brakeTemp += (g.brake*250 - (g.brakeTemp-100)*0.15) * dt
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// packet encodes the car state in the game format.
+func (g *Generator) packet() encoding.BinaryMarshaler {
+	pos, fwd := course(g.dist)
+	right := [3]float64{fwd[2], 0, -fwd[0]} // Y up x forward, left-handed
+	n := math.Hypot(right[0], right[2])
+	right[0], right[2] = right[0]/n, right[2]/n
+	k := curvature(g.dist)
+	steer := clamp(k*40+0.05*math.Sin(g.time*3), -1, 1) // with a little sweep of driver corrections
+	rpm := demoIdleRPM + (demoMaxRPM-demoIdleRPM)*clamp(g.speed/demoGearSpeed[g.gear], 0, 1)
+	clutch := 0.0
+	if g.shift > 0 {
+		clutch = 1
+	}
+	latG := g.speed * g.speed * k / demoGravity
+	lonG := (7*g.throttle - 11*g.brake) / demoGravity
+	susp := 5 * math.Sin(g.time*7) // mm
+	f := func(v float64) float32 { return float32(v) }
+	switch g.Format {
+	case codemasters.FormatEASportsWRC:
+		up := [3]float64{
+			fwd[1]*right[2] - fwd[2]*right[1],
+			fwd[2]*right[0] - fwd[0]*right[2],
+			fwd[0]*right[1] - fwd[1]*right[0],
+		}
+		return &codemasters.PacketEASportsWRC{
+			PacketUid:                 g.frame,
+			GameTotalTime:             f(g.time),
+			GameDeltaTime:             f(1 / g.Rate),
+			GameFrameCount:            g.frame,
+			ShiftlightsFraction:       f(clamp((rpm-5000)/(demoMaxRPM-5000), 0, 1)),
+			ShiftlightsRpmStart:       5000,
+			ShiftlightsRpmEnd:         demoMaxRPM,
+			ShiftlightsRpmValid:       true,
+			VehicleGearIndex:          uint8(g.gear),
+			VehicleGearIndexNeutral:   0,
+			VehicleGearIndexReverse:   demoMaxGears + 1,
+			VehicleGearMaximum:        demoMaxGears,
+			VehicleSpeed:              f(g.speed),
+			VehicleTransmissionSpeed:  f(g.speed),
+			VehiclePositionX:          f(pos[0]),
+			VehiclePositionY:          f(pos[1]),
+			VehiclePositionZ:          f(pos[2]),
+			VehicleVelocityX:          f(fwd[0] * g.speed),
+			VehicleVelocityY:          f(fwd[1] * g.speed),
+			VehicleVelocityZ:          f(fwd[2] * g.speed),
+			VehicleAccelerationX:      f((right[0]*latG + fwd[0]*lonG) * demoGravity),
+			VehicleAccelerationY:      f((right[1]*latG + fwd[1]*lonG) * demoGravity),
+			VehicleAccelerationZ:      f((right[2]*latG + fwd[2]*lonG) * demoGravity),
+			VehicleLeftDirectionX:     f(-right[0]),
+			VehicleLeftDirectionY:     f(-right[1]),
+			VehicleLeftDirectionZ:     f(-right[2]),
+			VehicleForwardDirectionX:  f(fwd[0]),
+			VehicleForwardDirectionY:  f(fwd[1]),
+			VehicleForwardDirectionZ:  f(fwd[2]),
+			VehicleUpDirectionX:       f(up[0]),
+			VehicleUpDirectionY:       f(up[1]),
+			VehicleUpDirectionZ:       f(up[2]),
+			VehicleHubPositionBl:      f(susp / 1000),
+			VehicleHubPositionBr:      f(-susp / 1000),
+			VehicleHubPositionFl:      f(-susp / 1000),
+			VehicleHubPositionFr:      f(susp / 1000),
+			VehicleCpForwardSpeedBl:   f(g.speed),
+			VehicleCpForwardSpeedBr:   f(g.speed),
+			VehicleCpForwardSpeedFl:   f(g.speed),
+			VehicleCpForwardSpeedFr:   f(g.speed),
+			VehicleBrakeTemperatureBl: f(g.brakeTemp * 0.8),
+			VehicleBrakeTemperatureBr: f(g.brakeTemp * 0.8),
+			VehicleBrakeTemperatureFl: f(g.brakeTemp),
+			VehicleBrakeTemperatureFr: f(g.brakeTemp),
+			VehicleEngineRpmMax:       demoMaxRPM,
+			VehicleEngineRpmIdle:      demoIdleRPM,
+			VehicleEngineRpmCurrent:   f(rpm),
+			VehicleThrottle:           f(g.throttle),
+			VehicleBrake:              f(g.brake),
+			VehicleClutch:             f(clutch),
+			VehicleSteering:           f(steer),
+			StageCurrentTime:          f(g.lapTime),
+			StageCurrentDistance:      g.dist,
+			StageLength:               demoLength,
+		}
+	default:
+		return &codemasters.PacketDirtSeries{
+			Time:                     f(g.time),
+			LapTime:                  f(g.lapTime),
+			LapDistance:              f(g.dist),
+			TotalDistance:            f(float64(g.lap)*demoLength + g.dist),
+			VehiclePosX:              f(pos[0]),
+			VehiclePosY:              f(pos[1]),
+			VehiclePosZ:              f(pos[2]),
+			VehicleSpeed:             f(g.speed),
+			VehicleVelX:              f(fwd[0] * g.speed),
+			VehicleVelY:              f(fwd[1] * g.speed),
+			VehicleVelZ:              f(fwd[2] * g.speed),
+			VehicleRightDirectionX:   f(right[0]),
+			VehicleRightDirectionY:   f(right[1]),
+			VehicleRightDirectionZ:   f(right[2]),
+			VehicleForwardDirectionX: f(fwd[0]),
+			VehicleForwardDirectionY: f(fwd[1]),
+			VehicleForwardDirectionZ: f(fwd[2]),
+			SuspPosBl:                f(susp),
+			SuspPosBr:                f(-susp),
+			SuspPosFl:                f(-susp),
+			SuspPosFr:                f(susp),
+			WheelSpeedBl:             f(g.speed),
+			WheelSpeedBr:             f(g.speed),
+			WheelSpeedFl:             f(g.speed),
+			WheelSpeedFr:             f(g.speed),
+			VehicleThrottle:          f(g.throttle),
+			VehicleSteering:          f(steer),
+			VehicleBrake:             f(g.brake),
+			VehicleClutch:            f(clutch),
+			VehicleGear:              f(float64(g.gear)),
+			GforceLat:                f(latG),
+			GforceLon:                f(lonG),
+			Lap:                      f(float64(g.lap)),
+			EngineRate:               f(rpm),
+			BrakesTemp:               [4]float32{f(g.brakeTemp * 0.8), f(g.brakeTemp * 0.8), f(g.brakeTemp), f(g.brakeTemp)},
+			TrackSize:                demoLength,
+			MaxRpm:                   demoMaxRPM,
+			IdleRpm:                  demoIdleRPM,
+			MaxGears:                 demoMaxGears,
+			ExtraData:                3,
+		}
+	}
+}
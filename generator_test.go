@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Every step is the next packet, float rounding of the game time must not skip or repeat one.
+func TestGeneratorSequence(t *testing.T) {
+	for _, format := range []codemasters.Format{codemasters.FormatEASportsWRC, codemasters.FormatDirtSeries} {
+		g, err := NewGenerator(format, 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, stats := sequencer{}, SequenceStats{}
+		for i := 0; i < 10000; i++ {
+			g.step(1 / g.Rate)
+			b, err := g.packet().MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkt, _, err := codemasters.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, ok := pkt.(*codemasters.PacketEASportsWRC); ok && (p.PacketUid != uint64(i+1) || p.GameFrameCount != p.PacketUid) {
+				t.Fatalf("step %d: packet uid %d, frame count %d", i+1, p.PacketUid, p.GameFrameCount)
+			}
+			stats.add(seq.check(pkt))
+		}
+		if stats.Received != 10000 || stats.Lost != 0 || stats.Duplicates != 0 || stats.Stale != 0 || stats.Resets != 0 {
+			t.Errorf("%s: %+v", format, stats)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Hook transitions, a SessionState name matches entering that state.
+const (
+	HookActivated   = "activated"
+	HookDeactivated = "deactivated"
+	HookStageStart  = "stage_start"
+	HookStageEnd    = "stage_end"
+)
+
+var hookTimeout = 10 * time.Second // per action, changed by tests
+
+// OBSRequest is an obs-websocket request.
+type OBSRequest struct {
+	RequestType string          `json:"requestType"`
+	RequestData json.RawMessage `json:"requestData,omitempty"`
+}
+
+// OBSRequests is parsed from a request or an array of requests.
+type OBSRequests []OBSRequest
+
+func (rs *OBSRequests) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		var r OBSRequest
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		*rs = OBSRequests{r}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]OBSRequest)(rs))
+}
+
+// Hook runs its actions, in this order, on the transitions in On.
+type Hook struct {
+	On       []string    `json:"on"`
+	Source   string      `json:"source,omitempty"`   // any source if empty
+	Driver   string      `json:"driver,omitempty"`   // the primary driver if empty, "*" for any
+	Webhook  string      `json:"webhook,omitempty"`  // POST the HookEvent as JSON
+	Command  []string    `json:"command,omitempty"`  // run with the HookEvent as JSON on stdin and HOOK_* variables
+	OBS      OBSRequests `json:"obs,omitempty"`      // sent to OBS_URL in a batch, or executed by the overlays loaded in OBS
+	Recorder string      `json:"recorder,omitempty"` // start or stop the recorder of the source
+}
+
+// HookEvent describes a transition of a driver.
+type HookEvent struct {
+	On      string
+	Source  string
+	Driver  string
+	State   SessionState
+	Active  bool
+	Time    time.Time
+	Session codemasters.SessionInfo
+}
+
+func (h *Hook) match(ev *HookEvent, primary bool) bool {
+	if h.Source != "" && h.Source != ev.Source {
+		return false
+	}
+	if h.Driver == "" && !primary || h.Driver != "" && h.Driver != "*" && h.Driver != ev.Driver {
+		return false
+	}
+	for _, on := range h.On {
+		if on == ev.On {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hook) validate() error {
+	if len(h.On) == 0 {
+		return fmt.Errorf("no transitions")
+	}
+	for _, on := range h.On {
+		switch SessionState(on) {
+		case HookActivated, HookDeactivated, HookStageStart, HookStageEnd,
+			StateIdle, StateMenu, StateRunning, StatePaused, StateFinished, StateReplay:
+		default:
+			return fmt.Errorf("unknown transition: %q", on)
+		}
+	}
+	switch h.Recorder {
+	case "", "start", "stop":
+	default:
+		return fmt.Errorf("unknown recorder action: %q", h.Recorder)
+	}
+	for _, r := range h.OBS {
+		if r.RequestType == "" {
+			return fmt.Errorf("obs request without requestType")
+		}
+	}
+	return nil
+}
+
+type hookJob struct {
+	hook *Hook
+	ev   HookEvent
+	pl   *Pipeline
+}
+
+// Hooks runs the actions of the hooks matching the transitions of drivers.
+type Hooks struct {
+	hooks []Hook
+	queue chan hookJob
+}
+
+func newHooks() *Hooks {
+	return &Hooks{queue: make(chan hookJob, 64)}
+}
+
+// LoadHooks reads a JSON array of Hook.
+func LoadHooks(name string) (*Hooks, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	hs := newHooks()
+	if err := json.Unmarshal(b, &hs.hooks); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for i := range hs.hooks {
+		if err := hs.hooks[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: hook %d: %w", name, i, err)
+		}
+	}
+	return hs, nil
+}
+
+// transitions returns the transitions of a driver from prev to p.
+func transitions(prev, p *Params) []string {
+	var res []string
+	if p.Active && !prev.Active {
+		res = append(res, HookActivated)
+	}
+	if p.State != prev.State {
+		if p.State == StateRunning && prev.State != StatePaused {
+			res = append(res, HookStageStart)
+		}
+		if p.State == StateFinished {
+			res = append(res, HookStageEnd)
+		}
+		res = append(res, string(p.State))
+	}
+	if !p.Active && prev.Active {
+		res = append(res, HookDeactivated)
+	}
+	return res
+}
+
+// Fire queues the actions of the hooks matching the transitions from prev to p,
+// they are dropped if the queue is full.
+func (hs *Hooks) Fire(pl *Pipeline, prev, p *Params) {
+	if hs == nil {
+		return
+	}
+	for _, on := range transitions(prev, p) {
+		ev := HookEvent{On: on, Source: p.Source, Driver: p.Driver, State: p.State, Active: p.Active, Time: p.received, Session: p.Session}
+		for i := range hs.hooks {
+			if !hs.hooks[i].match(&ev, p.primary) {
+				continue
+			}
+			select {
+			case hs.queue <- hookJob{hook: &hs.hooks[i], ev: ev, pl: pl}:
+			default:
+				log.Printf("hook dropped: %s %s (%s)", on, p.Driver, p.Source)
+			}
+		}
+	}
+}
+
+// Run runs the queued actions one by one until ctx is done.
+func (hs *Hooks) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-hs.queue:
+			job.run(ctx)
+		}
+	}
+}
+
+func (job *hookJob) run(ctx context.Context) {
+	h, ev := job.hook, &job.ev
+	body, _ := json.Marshal(ev)
+	if h.Webhook != "" {
+		if err := webhook(ctx, h.Webhook, body); err != nil {
+			log.Printf("hook %s: webhook: %v", ev.On, err)
+		}
+	}
+	if len(h.Command) > 0 {
+		if err := command(ctx, h.Command, ev, body); err != nil {
+			log.Printf("hook %s: command: %v", ev.On, err)
+		}
+	}
+	if len(h.OBS) > 0 {
+		if err := sendOBS(ctx, ev.Source, h.OBS); err != nil {
+			log.Printf("hook %s: obs: %v", ev.On, err)
+		}
+	}
+	switch h.Recorder {
+	case "start":
+		job.pl.recorder.Start()
+	case "stop":
+		job.pl.recorder.Stop()
+	}
+}
+
+func webhook(ctx context.Context, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func command(ctx context.Context, args []string, ev *HookEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"HOOK_EVENT="+ev.On,
+		"HOOK_SOURCE="+ev.Source,
+		"HOOK_DRIVER="+ev.Driver,
+		"HOOK_STATE="+string(ev.State),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
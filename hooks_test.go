@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHookMatch(t *testing.T) {
+	ev := &HookEvent{On: HookStageStart, Source: "wrc", Driver: "192.0.2.1:20777", State: StateRunning}
+	for _, c := range []struct {
+		name    string
+		hook    Hook
+		primary bool
+		want    bool
+	}{
+		{"any source, primary", Hook{On: []string{HookStageStart}}, true, true},
+		{"secondary driver", Hook{On: []string{HookStageStart}}, false, false},
+		{"source", Hook{On: []string{HookStageStart}, Source: "wrc"}, true, true},
+		{"other source", Hook{On: []string{HookStageStart}, Source: "dirt"}, true, false},
+		{"any driver", Hook{On: []string{HookStageStart}, Driver: "*"}, false, true},
+		{"named driver", Hook{On: []string{HookStageStart}, Driver: "192.0.2.1:20777"}, false, true},
+		{"other driver", Hook{On: []string{HookStageStart}, Driver: "192.0.2.2:20777"}, true, false},
+		{"state name", Hook{On: []string{string(StateRunning)}}, true, false},
+		{"one of", Hook{On: []string{HookStageEnd, HookStageStart}}, true, true},
+	} {
+		if got := c.hook.match(ev, c.primary); got != c.want {
+			t.Errorf("%s: match %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHookTransitions(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		prev, next Params
+		want       []string
+	}{
+		{"activated", Params{State: StateIdle}, Params{Active: true, State: StateMenu},
+			[]string{HookActivated, string(StateMenu)}},
+		{"stage start", Params{Active: true, State: StateMenu}, Params{Active: true, State: StateRunning},
+			[]string{HookStageStart, string(StateRunning)}},
+		{"resume", Params{Active: true, State: StatePaused}, Params{Active: true, State: StateRunning},
+			[]string{string(StateRunning)}},
+		{"stage end", Params{Active: true, State: StateRunning}, Params{Active: true, State: StateFinished},
+			[]string{HookStageEnd, string(StateFinished)}},
+		{"deactivated", Params{Active: true, State: StateRunning}, Params{State: StateIdle},
+			[]string{string(StateIdle), HookDeactivated}},
+		{"no change", Params{Active: true, State: StateRunning}, Params{Active: true, State: StateRunning}, nil},
+	} {
+		if got := transitions(&c.prev, &c.next); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHookValidate(t *testing.T) {
+	for _, h := range []Hook{
+		{},
+		{On: []string{"started"}},
+		{On: []string{HookStageStart}, Recorder: "pause"},
+		{On: []string{HookStageStart}, OBS: OBSRequests{{}}},
+	} {
+		if err := h.validate(); err == nil {
+			t.Errorf("%+v: no error", h)
+		}
+	}
+}
+
+// Actions run in order: webhook, command, OBS requests, recorder.
+func TestHookActionOrder(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	appendLog := func(s string) {
+		f, err := os.OpenFile(log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f.WriteString(s + "\n")
+		f.Close()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appendLog("webhook")
+	}))
+	defer srv.Close()
+	pl := &Pipeline{recorder: NewRecorder(t.TempDir())}
+	job := hookJob{
+		hook: &Hook{
+			Webhook:  srv.URL,
+			Command:  []string{"sh", "-c", `echo "command $HOOK_EVENT" >> "$0"`, log},
+			OBS:      OBSRequests{{RequestType: "SetCurrentProgramScene"}},
+			Recorder: "stop",
+		},
+		ev: HookEvent{On: HookStageStart, Source: "test"},
+		pl: pl,
+	}
+	job.run(context.Background())
+	if b, _ := os.ReadFile(log); string(b) != "webhook\ncommand stage_start\n" {
+		t.Errorf("log %q", b)
+	}
+	select {
+	case r := <-obsRequests:
+		if r.source != "test" || r.req.RequestType != "SetCurrentProgramScene" {
+			t.Errorf("obs request %+v", r)
+		}
+	default:
+		t.Error("no obs request")
+	}
+	pl.recorder.mu.Lock()
+	stopped := pl.recorder.stopped
+	pl.recorder.mu.Unlock()
+	if !stopped {
+		t.Error("recorder not stopped")
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	saved := hookTimeout
+	hookTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { hookTimeout = saved })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	start := time.Now()
+	if err := webhook(context.Background(), srv.URL, nil); err == nil {
+		t.Error("webhook: no timeout")
+	}
+	if err := command(context.Background(), []string{"sleep", "5"}, &HookEvent{}, nil); err == nil {
+		t.Error("command: no timeout")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("actions took %v", d)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/capture"
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+	"github.com/nobonobo/obs-codemasters-telemetry/pcap"
+)
+
+// pcapFilter selects datagrams by source or destination, zero values match any.
+type pcapFilter struct {
+	Port uint16
+	Host netip.Addr
+}
+
+func (f pcapFilter) match(d pcap.Datagram) bool {
+	if f.Port != 0 && d.Src.Port() != f.Port && d.Dst.Port() != f.Port {
+		return false
+	}
+	if f.Host.IsValid() && d.Src.Addr().Unmap() != f.Host.Unmap() && d.Dst.Addr().Unmap() != f.Host.Unmap() {
+		return false
+	}
+	return true
+}
+
+// readPcap calls fn with the matching datagrams of a pcap or pcapng file
+// as capture packets, the format is detected like live datagrams.
+// Data is valid until fn returns.
+func readPcap(r io.Reader, f pcapFilter, fn func(capture.Packet) error) error {
+	pr, err := pcap.NewReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !f.match(d) {
+			continue
+		}
+		p := capture.Packet{Time: d.Time, Addr: d.Src.String(), Data: d.Payload}
+		if spec, _, err := codemasters.Detect(d.Payload); err == nil {
+			p.Format = string(spec.Format)
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// importPcap converts pcap or pcapng files into capture files:
+//
+//	obs-codemasters-telemetry import [-port 20777] [-host addr] [-o out.cmtc] in.pcapng...
+func importPcap(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	port := fs.Uint("port", uint(config.PcapPort), "UDP port to extract, 0 for any")
+	host := fs.String("host", "", "source or destination address to extract, empty for any")
+	out := fs.String("o", "", "output file, default is the input file with .cmtc extension, existing files get a -N suffix")
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*out != "" && fs.NArg() > 1) {
+		fs.Usage()
+		return fmt.Errorf("import: one input with -o or any number of inputs expected")
+	}
+	f := pcapFilter{Port: uint16(*port), Host: config.PcapHost}
+	if *host != "" {
+		addr, err := netip.ParseAddr(*host)
+		if err != nil {
+			return err
+		}
+		f.Host = addr
+	}
+	for _, in := range fs.Args() {
+		name := *out
+		if name == "" {
+			name = strings.TrimSuffix(in, filepath.Ext(in)) + ".cmtc"
+		}
+		if err := convertPcap(in, name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertPcap(in, out string, f pcapFilter) error {
+	r, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var packets []capture.Packet
+	header := capture.Header{Software: softwareVersion()}
+	err = readPcap(r, f, func(p capture.Packet) error {
+		if header.Game == "" {
+			header.Game = p.Format
+		}
+		p.Data = append([]byte(nil), p.Data...)
+		packets = append(packets, p)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	if len(packets) == 0 {
+		return fmt.Errorf("%s: no matching datagrams", in)
+	}
+	header.Created = packets[0].Time
+	w, err := create(strings.TrimSuffix(out, filepath.Ext(out)), filepath.Ext(out)) // never overwrites a capture
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	cw, err := capture.NewWriter(w, header)
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		if err := cw.WritePacket(p); err != nil {
+			return err
+		}
+	}
+	if err := cw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("imported: %s -> %s (%d datagrams)", in, w.Name(), len(packets))
+	return w.Close()
+}
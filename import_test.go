@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/pcap"
+)
+
+// pcapFile returns a little endian microsecond pcap file of Ethernet frames
+// carrying UDP datagrams from 192.0.2.1:20777 to 192.0.2.2:20777.
+func pcapFile(payloads ...[]byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 0xa1b2c3d4)
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint32(b, 65535)
+	b = binary.LittleEndian.AppendUint32(b, 1) // Ethernet
+	for i, p := range payloads {
+		frame := append(make([]byte, 12), 0x08, 0x00)
+		ip := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, 17, 0, 0, 192, 0, 2, 1, 192, 0, 2, 2}
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+8+len(p)))
+		udp := binary.BigEndian.AppendUint16(nil, 20777)
+		udp = binary.BigEndian.AppendUint16(udp, 20777)
+		udp = binary.BigEndian.AppendUint16(udp, uint16(8+len(p)))
+		udp = append(udp, 0, 0)
+		frame = append(append(append(frame, ip...), udp...), p...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(1+i))
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(frame)))
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// Importing twice keeps the first capture.
+func TestConvertPcapExisting(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pcap")
+	if err := os.WriteFile(in, pcapFile(dirtPacket(t, 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "in.cmtc")
+	if err := convertPcap(in, out, pcapFilter{Port: 20777}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convertPcap(in, out, pcapFilter{Port: 20777}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(out); err != nil || !bytes.Equal(b, first) {
+		t.Errorf("first capture changed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "in-1.cmtc")); err != nil {
+		t.Errorf("second capture: %v", err)
+	}
+}
+
+func TestPcapFilter(t *testing.T) {
+	d := pcap.Datagram{Src: netip.MustParseAddrPort("192.0.2.1:50000"), Dst: netip.MustParseAddrPort("[::ffff:192.0.2.2]:20777")}
+	for _, c := range []struct {
+		f    pcapFilter
+		want bool
+	}{
+		{pcapFilter{}, true},
+		{pcapFilter{Port: 20777}, true},
+		{pcapFilter{Port: 50000}, true},
+		{pcapFilter{Port: 20778}, false},
+		{pcapFilter{Host: netip.MustParseAddr("192.0.2.1")}, true},
+		{pcapFilter{Host: netip.MustParseAddr("192.0.2.2")}, true}, // IPv4-mapped
+		{pcapFilter{Host: netip.MustParseAddr("192.0.2.3")}, false},
+		{pcapFilter{Port: 20777, Host: netip.MustParseAddr("192.0.2.3")}, false},
+	} {
+		if got := c.f.match(d); got != c.want {
+			t.Errorf("%+v: match %v, want %v", c.f, got, c.want)
+		}
+	}
+}
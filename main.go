@@ -10,8 +10,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	env "github.com/caarlos0/env/v6"
@@ -20,18 +24,56 @@ import (
 )
 
 type Config struct {
-	Index      int    `env:"INDEX" envDefault:"0"`
-	Listen     string `env:"LISTEN_UDP" envDefault:"127.0.0.1:20777"`
-	ListenHttp string `env:"LISTEN_HTTP" envDefault:"127.0.0.1:8123"`
+	Index       int                  `env:"INDEX" envDefault:"-1"` // F1 series car index, -1 follows the player car
+	Listen      string               `env:"LISTEN_UDP" envDefault:"127.0.0.1:20777"`
+	ListenHttp  string               `env:"LISTEN_HTTP" envDefault:"127.0.0.1:8123"`
+	Profiles    codemasters.Profiles `env:"PROFILES"`       // per game adjustments, e.g. "dirt:steer=-1"
+	Structures  []string             `env:"WRC_STRUCTURES"` // EA Sports WRC udp/*.json structure files
+	Channels    string               `env:"WRC_CHANNELS"`   // EA Sports WRC channels.json for custom channels
+	RecordDir   string               `env:"RECORD_DIR"`     // write capture files of every datagram into this directory
+	Replay      string               `env:"REPLAY"`         // replay this capture file instead of listening udp
+	ReplaySpeed float64              `env:"REPLAY_SPEED" envDefault:"1"`
+	ReplayLoop  bool                 `env:"REPLAY_LOOP"`
+	Demo        codemasters.Format   `env:"DEMO"`                         // generate demo telemetry in this format (dirt, easportswrc) instead of listening udp
+	DemoRate    float64              `env:"DEMO_RATE" envDefault:"60"`    // demo packets per second
+	PcapPort    uint16               `env:"PCAP_PORT" envDefault:"20777"` // UDP port read from pcap files, 0 for any
+	PcapHost    netip.Addr           `env:"PCAP_HOST"`                    // address read from pcap files, empty for any
+	Relay       []string             `env:"RELAY"`                        // forward raw datagrams to these "host:port"
+	Sources     Sources              `env:"SOURCES"`                      // named listeners "name=host:port,...", replaces LISTEN_UDP
+	Drivers     Aliases              `env:"DRIVERS"`                      // driver names "name=ip[:port],...", "ip:port" by default
+	PublishRate float64              `env:"PUBLISH_RATE" envDefault:"60"` // default SSE messages per second, 0 publishes every packet
+	Resample    ResampleMode         `env:"RESAMPLE" envDefault:"latest"` // default SSE resampling: latest, average or interpolate
+	Timeout     time.Duration        `env:"TIMEOUT" envDefault:"5s"`      // deactivate a driver without packets, TIMEOUT_<NAME> per source
+	Hooks       string               `env:"HOOKS"`                        // JSON file of actions run on driver transitions
+
+	ObsUrl          string     `env:"OBS_URL"` // obs-websocket v5 url, e.g. ws://127.0.0.1:4455, switches scenes from the server
+	ObsPassword     string     `env:"OBS_PASSWORD"`
+	ObsSource       string     `env:"OBS_SOURCE"` // source switching scenes, the first one by default
+	ObsScenePlaying string     `env:"OBS_SCENE_PLAYING" envDefault:"playing"`
+	ObsSceneReplay  string     `env:"OBS_SCENE_REPLAY" envDefault:"replay-mode"`
+	ObsRecord       RecordMode `env:"OBS_RECORD"`                                                        // record or replay-buffer on stage start and end
+	ObsFilename     string     `env:"OBS_FILENAME" envDefault:"{stage} {car} %CCYY-%MM-%DD %hh-%mm-%ss"` // OBS file name of stages
+	ObsSplits       Splits     `env:"OBS_SPLITS" envDefault:"0.25,0.5,0.75"`                             // chapters at these fractions of the stage
 }
 
 type Params struct {
+	Source   string
+	Driver   string
 	Steer    float32
 	Clutch   float32
 	Brake    float32
 	Throttle float32
-	Gear     int
+	Gear     int // -1 = reverse, 0 = neutral, 1.. forward
+	MaxGear  int
+	Speed    float32
 	Active   bool
+	Paused   bool
+	State    SessionState
+	Session  codemasters.SessionInfo
+	Frame    codemasters.Frame
+
+	primary  bool      // from the primary driver of the source
+	received time.Time // packet receive time
 }
 
 type Status struct {
@@ -51,18 +93,40 @@ func (status *Status) Deactivate() {
 	status.Active = false
 }
 
-func (status *Status) Update(pkt codemasters.Telemetry) {
+func (status *Status) Update(pkt codemasters.Telemetry, profiles codemasters.Profiles) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	pkt.Frame(&status.Frame)
+	prof := profiles.Get(status.Frame.Format)
+	prof.Apply(&status.Frame)
+	status.Steer = status.Frame.Steering
+	status.Clutch = status.Frame.Clutch
+	status.Brake = status.Frame.Brake
+	status.Throttle = status.Frame.Throttle
+	status.Gear = status.Frame.Gear
+	status.MaxGear = status.Frame.MaxGear
+	status.Speed = prof.SpeedScale * status.Frame.Speed
+}
+
+// Session applies a session lifecycle event.
+func (status *Status) Session(ev codemasters.Event, info codemasters.SessionInfo) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.Params.Session = info
+	switch ev {
+	case codemasters.EventSessionStart:
+		status.Active = true
+	case codemasters.EventSessionEnd:
+		status.Active = false
+	}
+}
+
+// SetState sets the session state, Paused follows it.
+func (status *Status) SetState(s SessionState) {
 	status.mu.Lock()
 	defer status.mu.Unlock()
-	pol := float32(1)
-	if pkt.StageDistance() == 0 { // for WRC Generations
-		pol = -1
-	}
-	status.Steer = pol * pkt.Steering()
-	status.Clutch = pkt.Clutch()
-	status.Brake = pkt.Brake()
-	status.Throttle = pkt.Throttle()
-	status.Gear = pkt.Gear()
+	status.State = s
+	status.Paused = s == StatePaused
 }
 
 func (status *Status) Get() Params {
@@ -72,8 +136,10 @@ func (status *Status) Get() Params {
 }
 
 var (
-	config Config
-	status Status
+	config    Config
+	pipelines []*Pipeline
+	relay     *Relay // nil unless RELAY is set
+	hooks     *Hooks // nil unless HOOKS is set
 )
 
 func init() {
@@ -82,104 +148,241 @@ func init() {
 	if err := env.Parse(&config); err != nil {
 		log.Fatal(err)
 	}
-}
-
-func udpReceiver(ctx context.Context, ch chan<- Params) error {
-	host, port, err := net.SplitHostPort(config.Listen)
-	if err != nil {
-		return err
+	if err := checkRate(config.PublishRate); err != nil {
+		log.Fatal("PUBLISH_RATE: ", err)
 	}
-	addr := net.JoinHostPort(host, port)
-	conn, err := net.ListenPacket("udp", addr)
+	pls, err := newPipelines()
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	defer conn.Close()
-	log.Println("listen udp:", addr)
-	defer log.Println("udp closed:", addr)
-	done := make(chan error, 1)
-	go func() {
-		timer := time.AfterFunc(5*time.Second, func() {
-			status.Deactivate()
-			p := status.Get()
-			p.Active = false
-			ch <- p
-		})
-		b := make([]byte, 4096)
-		last := time.Now()
-		for {
-			n, _, err := conn.ReadFrom(b)
-			if err != nil {
-				done <- err
-			}
-			now := time.Now()
-			if now.Sub(last) < 15*time.Millisecond {
-				continue
-			}
-			last = now
-			pkt, err := codemasters.Decode(b[:n])
-			if err != nil {
-				done <- err
-				continue
-			}
-			timer.Reset(5 * time.Second)
-			status.Activate()
-			status.Update(pkt)
-			ch <- status.Get()
+	pipelines = pls
+	if len(config.Relay) > 0 {
+		r, err := NewRelay(config.Relay)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
-	select {
-	case err := <-done:
-		return err
-	case <-ctx.Done():
+		relay = r
+	}
+	if config.Hooks != "" {
+		hs, err := LoadHooks(config.Hooks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hooks = hs
+	}
+	if config.ObsUrl != "" {
+		if err := newOBS(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.ObsRecord != RecordOff {
+		sr, err := newStageRecorder()
+		if err != nil {
+			log.Fatal(err)
+		}
+		stageRec = sr
+	}
+}
+
+func loadStructures() error {
+	if config.Channels != "" {
+		f, err := os.Open(config.Channels)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := codemasters.LoadEASportsWRCChannels(f); err != nil {
+			return fmt.Errorf("%s: %w", config.Channels, err)
+		}
+	}
+	for _, name := range config.Structures {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		s, err := codemasters.LoadEASportsWRCStructure(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := codemasters.RegisterEASportsWRCStructure(s); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		log.Printf("structure loaded: %s (%s)", name, s.Format())
 	}
 	return nil
 }
 
+// subscription receives the Params of a driver of a source,
+// of the primary driver if driver is empty.
+type subscription struct {
+	ch      chan Params
+	obs     chan<- OBSRequest // requests of hooks to the overlays of the source
+	source  string
+	driver  string
+	dropped uint64 // oldest messages dropped for a slow subscriber
+}
+
+// overlayRequest is an OBS request of a hook, executed by the overlays of source.
+type overlayRequest struct {
+	source string
+	req    OBSRequest
+}
+
 var (
-	subscribe   = make(chan chan<- Params, 1)
-	unsubscribe = make(chan chan<- Params, 1)
+	subscribe   = make(chan subscription, 1)
+	unsubscribe = make(chan chan Params, 1)
+	obsRequests = make(chan overlayRequest, 16)
+	procDone    = make(chan struct{}) // closed when proc returns
 )
 
+// proc fans the published Params out to the subscribers without blocking:
+// a slow subscriber loses its oldest messages, so the sources never wait for it.
 func proc(ctx context.Context, publish <-chan Params) {
-	m := map[chan<- Params]struct{}{}
+	defer close(procDone)
+	m := map[chan Params]*subscription{}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case v := <-subscribe:
-			m[v] = struct{}{}
+			m[v.ch] = &v
 		case v := <-unsubscribe:
+			if sub := m[v]; sub != nil && sub.dropped > 0 {
+				log.Printf("subscriber dropped %d messages (%s)", sub.dropped, sub.source)
+			}
 			delete(m, v)
 			close(v)
 		case v := <-publish:
-			for c := range m {
-				c <- v
+			for c, sub := range m {
+				if sub.source != v.Source {
+					continue
+				}
+				if sub.driver == v.Driver || (sub.driver == "" && v.primary) {
+					select {
+					case c <- v:
+					default: // full, replace the oldest with the latest
+						select {
+						case <-c:
+							sub.dropped++
+						default:
+						}
+						c <- v // proc is the only sender, there is room
+					}
+				}
+			}
+		case v := <-obsRequests:
+			for _, sub := range m {
+				if sub.source != v.source {
+					continue
+				}
+				select {
+				case sub.obs <- v.req:
+				default: // the overlay is behind, skip it
+				}
 			}
 		}
 	}
 }
 
+// sse streams the Params of ?source=name, the first source by default,
+// and ?driver=name, the primary driver by default,
+// at ?rate=messages per second resampled by ?resample=mode.
 func sse(w http.ResponseWriter, r *http.Request) {
-	log.Printf("connect from: %v", r.RemoteAddr)
+	q := r.URL.Query()
+	pl := findPipeline(q.Get("source"))
+	if pl == nil {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+	driver := q.Get("driver")
+	rate, mode := config.PublishRate, config.Resample
+	if v := q.Get("rate"); v != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || checkRate(rate) != nil {
+			http.Error(w, "invalid rate: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("resample"); v != "" {
+		if err := mode.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("connect from: %v (source=%s driver=%s rate=%v %s)", r.RemoteAddr, pl.Name, driver, rate, mode)
 	defer log.Printf("disconnect from: %v", r.RemoteAddr)
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	ch := make(chan Params, 64)
-	subscribe <- ch
+	requests := make(chan OBSRequest, 8)
+	select {
+	case subscribe <- subscription{ch: ch, obs: requests, source: pl.Name, driver: driver}:
+	case <-procDone:
+		return
+	}
 	defer func() {
-		unsubscribe <- ch
+		go func() {
+			for range ch { // until closed by proc
+			}
+		}()
+		select {
+		case unsubscribe <- ch:
+		case <-procDone:
+		}
 	}()
 	timeout := time.NewTicker(30 * time.Second)
+	defer timeout.Stop()
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	// overlays leave the scenes to the server when it controls OBS
+	fmt.Fprintf(w, "event: config\ndata: {\"ServerScenes\":%t}\n\n", obs != nil)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	rs := &resampler{mode: mode}
+	var state SessionState
+	send := func(v Params) {
+		timeout.Reset(30 * time.Second)
+		if v.State != state {
+			b, _ := json.Marshal(StateEvent{Source: v.Source, Driver: v.Driver, State: v.State, Previous: state})
+			fmt.Fprintf(w, "event: state\ndata: %s\n\n", string(b))
+			state = v.State
+		}
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", string(b))
+	}
 	for {
 		select {
 		case <-r.Context().Done():
 			return
 		case v := <-ch:
-			timeout.Reset(30 * time.Second)
-			b, _ := json.Marshal(v)
-			fmt.Fprintf(w, "data: %s\n\n", string(b))
+			switch {
+			case tick == nil:
+				send(v)
+			case rs.changed(&v):
+				if p, ok := rs.last(); ok {
+					send(p)
+				}
+				send(rs.flush(v))
+			default:
+				rs.add(v)
+				continue
+			}
+		case now := <-tick:
+			p, ok := rs.next(now)
+			if !ok {
+				continue
+			}
+			send(p)
+		case req := <-requests:
+			b, _ := json.Marshal(req)
+			fmt.Fprintf(w, "event: obs\ndata: %s\n\n", string(b))
 		case <-timeout.C:
 			fmt.Fprintf(w, "data: \n\n")
 		}
@@ -201,64 +404,141 @@ func forwardProc(ctx context.Context, p1, p2 string) error {
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
+	dstOnce := sync.Once{}
+	defer dstOnce.Do(func() { dst.Close() })
 
+	stop := context.AfterFunc(ctx, func() { // unblocks the copies
+		srcOnce.Do(func() { src.Close() })
+		dstOnce.Do(func() { dst.Close() })
+	})
+	defer stop()
 	go func() {
 		defer srcOnce.Do(func() { src.Close() })
 		io.Copy(os.Stdout, dst)
 	}()
-	if _, err := io.Copy(dst, src); err != nil {
+	if _, err := io.Copy(dst, src); err != nil && ctx.Err() == nil {
 		return err
 	}
 	return nil
 }
 
-func forward(ctx context.Context) {
-	if len(os.Args) != 3 {
-		return
+// serveHTTP serves until ctx is done, then waits for the handlers to return.
+// Requests, e.g. SSE streams, are cancelled with ctx.
+func serveHTTP(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:        config.ListenHttp,
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
-	p1, p2 := os.Args[1], os.Args[2]
-	for {
-		if err := forwardProc(ctx, p1, p2); err != nil {
-			log.Print(err)
-		}
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-		time.Sleep(10 * time.Second)
+	errc := make(chan error, 1)
+	go func() {
+		log.Print("listen start http:", config.ListenHttp)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
 	}
+	sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer log.Print("http closed:", config.ListenHttp)
+	return srv.Shutdown(sctx)
 }
 
 //go:embed static/*
 var contents embed.FS
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go forward(ctx)
+	if err := loadStructures(); err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importPcap(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// proc outlives the other components, so they never block publishing
+	procCtx, stopProc := context.WithCancel(context.Background())
 	ch := make(chan Params, 64)
-	go func() {
-		for {
-			if err := udpReceiver(ctx, ch); err != nil {
-				log.Print(err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			break
+	go proc(procCtx, ch)
+
+	var sup supervisor
+	if len(os.Args) == 3 {
+		p1, p2 := os.Args[1], os.Args[2]
+		sup.Go(ctx, "serial forward", restartAlways, func(ctx context.Context) error {
+			return forwardProc(ctx, p1, p2)
+		})
+	}
+	if relay != nil {
+		sup.Go(ctx, "relay", restartNever, func(ctx context.Context) error {
+			relay.Run(ctx)
+			return nil
+		})
+	}
+	// obs outlives the other components, so the stage recorder restores the OBS profile on shutdown
+	obsCtx, stopOBS := context.WithCancel(context.Background())
+	var obsSup supervisor
+	if obs != nil {
+		obsSup.Go(obsCtx, "obs", restartAlways, obs.Run)
+	}
+	if stageRec != nil {
+		sup.Go(ctx, "obs record", restartNever, func(ctx context.Context) error {
+			stageRec.Run(ctx)
+			return nil
+		})
+	}
+	if hooks != nil {
+		sup.Go(ctx, "hooks", restartNever, func(ctx context.Context) error {
+			hooks.Run(ctx)
+			return nil
+		})
+	}
+	if config.Replay != "" {
+		rp, err := LoadReplay(config.Replay, config.ReplaySpeed, config.ReplayLoop)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
-	go proc(ctx, ch)
+		http.Handle("/replay", rp)
+		http.Handle("/replay/", rp)
+		pipelines[0].Source, pipelines[0].Live = rp, false
+	} else if config.Demo != "" {
+		gen, err := NewGenerator(config.Demo, config.DemoRate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pipelines[0].Source, pipelines[0].Live = gen, false
+	}
+	for _, pl := range pipelines {
+		pl := pl
+		sup.Go(ctx, "source "+pl.Name, restartOnFailure, func(ctx context.Context) error {
+			return pl.Run(ctx, ch)
+		})
+	}
 	static, err := fs.Sub(contents, "static")
 	if err != nil {
 		log.Fatal(err)
 	}
 	http.Handle("/", http.FileServer(http.FS(static)))
 	http.Handle("/sse", http.HandlerFunc(sse))
-	log.Print("listen start http:", config.ListenHttp)
-	defer log.Print("program terminated")
-	if err := http.ListenAndServe(config.ListenHttp, nil); err != nil {
-		log.Fatal(err)
+	http.Handle("/sources", http.HandlerFunc(serveSources))
+	http.Handle("/drivers", http.HandlerFunc(serveDrivers))
+	http.Handle("/stats", http.HandlerFunc(serveStats))
+	http.Handle("/relay", relay)
+	http.Handle("/relay/", relay)
+	sup.Go(ctx, "http", restartOnFailure, serveHTTP)
+
+	<-ctx.Done()
+	stop() // a second signal terminates at once
+	log.Print("shutting down")
+	sup.Wait()
+	stopOBS()
+	obsSup.Wait()
+	stopProc()
+	for _, pl := range pipelines {
+		pl.recorder.Close()
 	}
+	log.Print("program terminated")
 }
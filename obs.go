@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/obsws"
+)
+
+// obs switches scenes from the server, nil unless OBS_URL is set.
+var obs *obsws.Client
+
+// newOBS creates the OBS client and adds the hooks switching to the playing scene
+// when the driver of OBS_SOURCE activates and back to the replay scene when it deactivates.
+func newOBS() error {
+	source := config.ObsSource
+	if source == "" {
+		source = pipelines[0].Name
+	} else if findPipeline(source) == nil {
+		return fmt.Errorf("OBS_SOURCE: unknown source: %q", source)
+	}
+	obs = &obsws.Client{URL: config.ObsUrl, Password: config.ObsPassword}
+	obs.OnConnect = func() { syncScene(source) }
+	if hooks == nil {
+		hooks = newHooks()
+	}
+	hooks.hooks = append(hooks.hooks,
+		Hook{On: []string{HookActivated}, Source: source, OBS: OBSRequests{sceneRequest(config.ObsScenePlaying)}},
+		Hook{On: []string{HookDeactivated}, Source: source, OBS: OBSRequests{sceneRequest(config.ObsSceneReplay)}},
+	)
+	return nil
+}
+
+func sceneRequest(scene string) OBSRequest {
+	b, _ := json.Marshal(map[string]string{"sceneName": scene})
+	return OBSRequest{RequestType: "SetCurrentProgramScene", RequestData: b}
+}
+
+// syncScene switches to the scene of the current state of source, e.g. after connecting.
+func syncScene(source string) {
+	scene := config.ObsSceneReplay
+	if pl := findPipeline(source); pl != nil && pl.active() {
+		scene = config.ObsScenePlaying
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	if err := sendOBS(ctx, source, OBSRequests{sceneRequest(scene)}); err != nil {
+		log.Print("obs: ", err)
+	}
+}
+
+// sendOBS sends requests to OBS in a batch halted by the first failure,
+// or to the overlays of source if OBS_URL is not set.
+func sendOBS(ctx context.Context, source string, reqs OBSRequests) error {
+	if obs == nil {
+		for _, r := range reqs {
+			select {
+			case obsRequests <- overlayRequest{source: source, req: r}:
+			case <-procDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	if len(reqs) == 1 {
+		var data any
+		if len(reqs[0].RequestData) > 0 {
+			data = reqs[0].RequestData
+		}
+		_, err := obs.Request(ctx, reqs[0].RequestType, data)
+		return err
+	}
+	batch := make([]obsws.Request, len(reqs))
+	for i, r := range reqs {
+		batch[i] = obsws.Request{RequestType: r.RequestType, RequestData: r.RequestData}
+	}
+	res, err := obs.Batch(ctx, true, batch...)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if err := r.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/obsws"
+)
+
+// RecordMode selects what OBS records on stage start and end.
+type RecordMode string
+
+const (
+	RecordOff          RecordMode = ""
+	RecordStage        RecordMode = "record"        // record each stage attempt into its own file
+	RecordReplayBuffer RecordMode = "replay-buffer" // save the replay buffer at the end of each stage
+)
+
+func (m *RecordMode) UnmarshalText(text []byte) error {
+	switch v := RecordMode(text); v {
+	case RecordOff, RecordStage, RecordReplayBuffer:
+		*m = v
+		return nil
+	}
+	return fmt.Errorf("unknown record mode: %q", text)
+}
+
+// Splits are fractions of the stage length, parsed from "0.25,0.5,0.75".
+type Splits []float32
+
+func (s *Splits) UnmarshalText(text []byte) error {
+	res := Splits{}
+	for _, v := range strings.Split(string(text), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil || f <= 0 || f >= 1 {
+			return fmt.Errorf("invalid split: %q", v)
+		}
+		res = append(res, float32(f))
+	}
+	*s = res
+	return nil
+}
+
+type stageEventKind int
+
+const (
+	stageStart  stageEventKind = iota
+	stageSplit                 // n is the split number
+	stageFinish                // the stage is completed
+	stageAbort                 // left the stage, e.g. restart, quit or replay
+	stageLeave                 // the driver is deactivated
+)
+
+type stageEvent struct {
+	kind stageEventKind
+	n    int
+	p    Params
+}
+
+type chapter struct {
+	offset time.Duration // from the start of the recording
+	name   string
+}
+
+// stageRecorder starts and stops OBS recordings, or saves the replay buffer,
+// on the stages of the primary driver of a source, with chapters at the splits.
+type stageRecorder struct {
+	mode     RecordMode
+	source   string
+	filename string // OBS FilenameFormatting with {source}, {driver}, {stage} and {car}
+	splits   Splits
+	queue    chan stageEvent
+	outputs  chan string // output events awaited by the worker, see onEvent
+
+	// worker state
+	active   bool // in a stage
+	started  time.Time
+	chapters []chapter
+	profile  string // FilenameFormatting of the OBS profile
+	renamed  bool   // FilenameFormatting is changed until restore
+	buffer   bool   // the replay buffer is started until leave
+}
+
+// stageRec is nil unless OBS_RECORD is set.
+var stageRec *stageRecorder
+
+func newStageRecorder() (*stageRecorder, error) {
+	if obs == nil {
+		return nil, fmt.Errorf("OBS_RECORD needs OBS_URL")
+	}
+	source := config.ObsSource
+	if source == "" {
+		source = pipelines[0].Name
+	}
+	sr := &stageRecorder{
+		mode:     config.ObsRecord,
+		source:   source,
+		filename: config.ObsFilename,
+		splits:   config.ObsSplits,
+		queue:    make(chan stageEvent, 16),
+		outputs:  make(chan string, 4),
+	}
+	obs.Events |= obsws.EventOutputs
+	obs.OnEvent = sr.onEvent
+	return sr, nil
+}
+
+// observe queues the stage events between two params of a driver.
+func (sr *stageRecorder) observe(prev, p *Params) {
+	if sr == nil || p.Source != sr.source || !p.primary {
+		return
+	}
+	for _, on := range transitions(prev, p) {
+		switch SessionState(on) {
+		case HookStageStart:
+			sr.send(stageEvent{kind: stageStart, p: *p})
+		case HookStageEnd:
+			sr.send(stageEvent{kind: stageFinish, p: *p})
+		case HookDeactivated:
+			sr.send(stageEvent{kind: stageLeave, p: *p})
+		case StateIdle, StateMenu, StateReplay:
+			sr.send(stageEvent{kind: stageAbort, p: *p})
+		}
+	}
+	if p.State != StateRunning {
+		return
+	}
+	for i, s := range sr.splits {
+		if prev.Frame.StageProgress < s && s <= p.Frame.StageProgress {
+			sr.send(stageEvent{kind: stageSplit, n: i + 1, p: *p})
+		}
+	}
+}
+
+func (sr *stageRecorder) send(ev stageEvent) {
+	select {
+	case sr.queue <- ev:
+	default:
+		log.Print("obs record: event dropped")
+	}
+}
+
+// Run handles the queued events one by one until ctx is done,
+// then stops the stage and the replay buffer and restores the filename of the OBS profile.
+func (sr *stageRecorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+			defer cancel()
+			sr.leave(ctx)
+			sr.restore(ctx)
+			return
+		case ev := <-sr.queue:
+			ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+			sr.handle(ctx, ev)
+			cancel()
+		}
+	}
+}
+
+func (sr *stageRecorder) handle(ctx context.Context, ev stageEvent) {
+	p := &ev.p
+	switch ev.kind {
+	case stageStart:
+		if sr.active { // restarted without leaving the stage
+			sr.stop(ctx, "Restart", false)
+		}
+		sr.start(ctx, p)
+	case stageSplit:
+		if sr.active {
+			_, clock := gameClock(&p.Frame)
+			sr.chapter(ctx, fmt.Sprintf("Split %d %s", ev.n, formatClock(clock)))
+		}
+	case stageFinish:
+		if !sr.active {
+			return
+		}
+		t := p.Session.StageResultTime
+		if t <= 0 {
+			_, t = gameClock(&p.Frame)
+		}
+		sr.stop(ctx, "Finish "+formatClock(t), true)
+	case stageAbort:
+		if sr.active {
+			sr.stop(ctx, "Abort", false)
+		}
+	case stageLeave:
+		sr.leave(ctx)
+	}
+}
+
+// leave aborts the stage and stops the replay buffer started by start.
+func (sr *stageRecorder) leave(ctx context.Context) {
+	if sr.active {
+		sr.stop(ctx, "Abort", false)
+	}
+	if !sr.buffer {
+		return
+	}
+	if _, err := obs.Request(ctx, "StopReplayBuffer", nil); err != nil {
+		log.Print("obs record: ", err)
+		return
+	}
+	sr.buffer = false
+	log.Print("obs record: StopReplayBuffer")
+}
+
+// start saves the filename of the OBS profile, names the file of the stage
+// and starts the recording or the replay buffer.
+func (sr *stageRecorder) start(ctx context.Context, p *Params) {
+	if !sr.renamed {
+		b, err := obs.Request(ctx, "GetProfileParameter", filenameParameter())
+		if err != nil {
+			log.Print("obs record: ", err)
+			return
+		}
+		var resp struct {
+			ParameterValue string `json:"parameterValue"`
+		}
+		json.Unmarshal(b, &resp)
+		sr.profile = resp.ParameterValue
+	}
+	name := sr.name(p)
+	req := obsws.Request{RequestType: "StartRecord"}
+	if sr.mode == RecordReplayBuffer {
+		req.RequestType = "StartReplayBuffer"
+	}
+	param := filenameParameter()
+	param["parameterValue"] = name
+	data, _ := json.Marshal(param)
+	sr.renamed = true
+	res, err := obs.Batch(ctx, true, obsws.Request{RequestType: "SetProfileParameter", RequestData: data}, req)
+	if err == nil {
+		for _, r := range res {
+			if err = r.Err(); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil && !(sr.mode == RecordReplayBuffer && isOutputRunning(err)) {
+		log.Print("obs record: ", err)
+		sr.restore(ctx)
+		return
+	}
+	if err == nil && sr.mode == RecordReplayBuffer {
+		sr.buffer = true
+	}
+	sr.active, sr.started, sr.chapters = true, time.Now(), nil
+	log.Printf("obs record: %s %s", req.RequestType, name)
+}
+
+// stop ends the stage with a last chapter, then stops the recording
+// or saves the replay buffer if the stage is completed, and restores the filename.
+// It waits until OBS has stopped or saved the output, so a restart can start it again.
+func (sr *stageRecorder) stop(ctx context.Context, last string, completed bool) {
+	sr.chapter(ctx, last)
+	sr.active = false
+	defer sr.restore(ctx)
+	sr.drain()
+	switch sr.mode {
+	case RecordStage:
+		b, err := obs.Request(ctx, "StopRecord", nil)
+		if err != nil {
+			log.Print("obs record: ", err)
+			return
+		}
+		var resp struct {
+			OutputPath string `json:"outputPath"`
+		}
+		json.Unmarshal(b, &resp)
+		log.Print("obs record: saved ", resp.OutputPath)
+		sr.writeChapters(resp.OutputPath)
+		sr.wait(ctx, "RecordStateChanged")
+	case RecordReplayBuffer:
+		// saved asynchronously, the ReplayBufferSaved event logs the file;
+		// the chapters don't apply to it, its start depends on the buffer length
+		if completed {
+			if _, err := obs.Request(ctx, "SaveReplayBuffer", nil); err != nil {
+				log.Print("obs record: ", err)
+				return
+			}
+			sr.wait(ctx, "ReplayBufferSaved")
+		}
+	}
+}
+
+// restore sets the filename of the OBS profile back after start.
+func (sr *stageRecorder) restore(ctx context.Context) {
+	if !sr.renamed {
+		return
+	}
+	param := filenameParameter()
+	param["parameterValue"] = sr.profile
+	if _, err := obs.Request(ctx, "SetProfileParameter", param); err != nil {
+		log.Print("obs record: restore filename: ", err)
+		return
+	}
+	sr.renamed = false
+}
+
+func filenameParameter() map[string]string {
+	return map[string]string{"parameterCategory": "Output", "parameterName": "FilenameFormatting"}
+}
+
+// drain forgets the output events received before a request.
+func (sr *stageRecorder) drain() {
+	for {
+		select {
+		case <-sr.outputs:
+		default:
+			return
+		}
+	}
+}
+
+// wait waits for the output event eventType.
+func (sr *stageRecorder) wait(ctx context.Context, eventType string) {
+	for {
+		select {
+		case ev := <-sr.outputs:
+			if ev == eventType {
+				return
+			}
+		case <-ctx.Done():
+			log.Printf("obs record: no %s event: %v", eventType, ctx.Err())
+			return
+		}
+	}
+}
+
+// chapter logs a chapter and adds it to the recording, if its format supports chapters (Hybrid MP4).
+func (sr *stageRecorder) chapter(ctx context.Context, name string) {
+	offset := time.Since(sr.started)
+	sr.chapters = append(sr.chapters, chapter{offset: offset, name: name})
+	log.Printf("obs record: chapter %s at %s", name, formatOffset(offset))
+	if sr.mode != RecordStage {
+		return
+	}
+	if _, err := obs.Request(ctx, "CreateRecordChapter", map[string]string{"chapterName": name}); err != nil {
+		log.Print("obs record: ", err)
+	}
+}
+
+// onEvent logs the files saved by OBS and passes the end of the outputs to the worker.
+func (sr *stageRecorder) onEvent(eventType string, data json.RawMessage) {
+	switch eventType {
+	case "RecordStateChanged":
+		var ev struct {
+			OutputState string `json:"outputState"`
+		}
+		json.Unmarshal(data, &ev)
+		if ev.OutputState != "OBS_WEBSOCKET_OUTPUT_STOPPED" {
+			return
+		}
+	case "ReplayBufferSaved":
+		var ev struct {
+			SavedReplayPath string `json:"savedReplayPath"`
+		}
+		json.Unmarshal(data, &ev)
+		log.Print("obs record: saved ", ev.SavedReplayPath)
+	default:
+		return
+	}
+	select {
+	case sr.outputs <- eventType:
+	default:
+	}
+}
+
+// writeChapters writes the chapters next to the OBS file, if it is on this machine.
+func (sr *stageRecorder) writeChapters(path string) {
+	if path == "" || len(sr.chapters) == 0 {
+		return
+	}
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(formatOffset(0) + " Start\n")
+	for _, c := range sr.chapters {
+		fmt.Fprintf(&b, "%s %s\n", formatOffset(c.offset), c.name)
+	}
+	name := strings.TrimSuffix(path, filepath.Ext(path)) + ".chapters.txt"
+	if err := os.WriteFile(name, []byte(b.String()), 0o644); err != nil {
+		log.Print("obs record: ", err)
+	}
+}
+
+// name expands the filename template, OBS expands the time specifiers, e.g. %CCYY.
+func (sr *stageRecorder) name(p *Params) string {
+	stage, car := "stage", string(p.Frame.Format)
+	switch {
+	case p.Session.LocationId != 0 || p.Session.RouteId != 0:
+		stage = fmt.Sprintf("location%d-route%d", p.Session.LocationId, p.Session.RouteId)
+	case p.Frame.StageLength > 0:
+		stage = fmt.Sprintf("stage%dm", int(math.Round(float64(p.Frame.StageLength))))
+	}
+	if p.Session.VehicleId != 0 {
+		car = fmt.Sprintf("vehicle%d", p.Session.VehicleId)
+	}
+	return strings.NewReplacer(
+		"{source}", sanitize(p.Source),
+		"{driver}", sanitize(p.Driver),
+		"{stage}", sanitize(stage),
+		"{car}", sanitize(car),
+	).Replace(sr.filename)
+}
+
+// sanitize replaces characters invalid in file names.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|%`, r) {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+// formatClock formats seconds as m:ss.mmm.
+func formatClock(sec float32) string {
+	ms := int(math.Round(float64(sec) * 1000))
+	return fmt.Sprintf("%d:%02d.%03d", ms/60000, ms/1000%60, ms%1000)
+}
+
+// formatOffset formats d as hh:mm:ss.
+func formatOffset(d time.Duration) string {
+	s := int(d.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// isOutputRunning reports whether err is the failure of starting a running output.
+func isOutputRunning(err error) bool {
+	re, ok := err.(*obsws.RequestError)
+	return ok && re.Code == 500
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+	"github.com/nobonobo/obs-codemasters-telemetry/obsws"
+)
+
+// fakeOutputs logs the requests of the fake OBS and emits the output events
+// like OBS: later than the response of StopRecord and SaveReplayBuffer.
+type fakeOutputs struct {
+	srv       *obsws.FakeServer
+	mu        sync.Mutex
+	log       []string
+	buffering bool
+}
+
+func (f *fakeOutputs) add(s string) {
+	f.mu.Lock()
+	f.log = append(f.log, s)
+	f.mu.Unlock()
+}
+
+func (f *fakeOutputs) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+func (f *fakeOutputs) later(eventType string, data any) {
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.add(eventType)
+		f.srv.Event(eventType, data)
+	}()
+}
+
+func (f *fakeOutputs) respond(req obsws.Request) obsws.Response {
+	var data map[string]string
+	json.Unmarshal(req.RequestData, &data)
+	f.add(strings.TrimSpace(req.RequestType + " " + data["parameterValue"] + data["chapterName"]))
+	resp := obsws.FakeResponse(req)
+	switch req.RequestType {
+	case "GetProfileParameter":
+		resp.ResponseData = json.RawMessage(`{"parameterValue":"%CCYY"}`)
+	case "StopRecord":
+		resp.ResponseData = json.RawMessage(`{"outputPath":""}`)
+		f.later("RecordStateChanged", map[string]any{"outputActive": false, "outputState": "OBS_WEBSOCKET_OUTPUT_STOPPED"})
+	case "SaveReplayBuffer":
+		f.later("ReplayBufferSaved", map[string]string{"savedReplayPath": "replay.mkv"})
+	case "StartReplayBuffer":
+		f.mu.Lock()
+		if f.buffering {
+			resp.RequestStatus = obsws.RequestStatus{Code: 500, Comment: "output running"}
+		}
+		f.buffering = true
+		f.mu.Unlock()
+	case "StopReplayBuffer":
+		f.mu.Lock()
+		f.buffering = false
+		f.mu.Unlock()
+	}
+	return resp
+}
+
+// testStageRecorder connects the obs client to a fake OBS until the test ends.
+func testStageRecorder(t *testing.T, mode RecordMode) (*stageRecorder, *fakeOutputs) {
+	f := &fakeOutputs{srv: obsws.NewFakeServer("")}
+	f.srv.Respond = f.respond
+	srv := httptest.NewServer(f.srv)
+	t.Cleanup(srv.Close)
+
+	saved := obs
+	obs = &obsws.Client{URL: "ws" + strings.TrimPrefix(srv.URL, "http")}
+	sr := &stageRecorder{mode: mode, source: "test", filename: "{stage}", queue: make(chan stageEvent, 16), outputs: make(chan string, 4)}
+	obs.Events |= obsws.EventOutputs
+	obs.OnEvent = sr.onEvent
+	connected := make(chan struct{})
+	obs.OnConnect = func() { close(connected) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { obs.Run(ctx); close(done) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		obs = saved
+	})
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected")
+	}
+	return sr, f
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func stageParams(clock float32) Params {
+	return Params{Source: "test", Active: true, State: StateRunning, Frame: codemasters.Frame{
+		Available: codemasters.ChannelStage, StageLength: 1000, StageTime: clock,
+	}}
+}
+
+func TestStageRecorderRecord(t *testing.T) {
+	sr, f := testStageRecorder(t, RecordStage)
+	ctx := testContext(t)
+	sr.handle(ctx, stageEvent{kind: stageStart, p: stageParams(0)})
+	sr.handle(ctx, stageEvent{kind: stageSplit, n: 1, p: stageParams(12.5)})
+	sr.handle(ctx, stageEvent{kind: stageStart, p: stageParams(0)}) // restart
+	sr.handle(ctx, stageEvent{kind: stageAbort, p: stageParams(3)})
+	sr.handle(ctx, stageEvent{kind: stageLeave, p: stageParams(3)})
+	want := []string{
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartRecord",
+		"CreateRecordChapter Split 1 0:12.500",
+		"CreateRecordChapter Restart", "StopRecord", "RecordStateChanged", "SetProfileParameter %CCYY",
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartRecord",
+		"CreateRecordChapter Abort", "StopRecord", "RecordStateChanged", "SetProfileParameter %CCYY",
+	}
+	if got := f.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestStageRecorderReplayBuffer(t *testing.T) {
+	sr, f := testStageRecorder(t, RecordReplayBuffer)
+	ctx := testContext(t)
+	sr.handle(ctx, stageEvent{kind: stageStart, p: stageParams(0)})
+	sr.handle(ctx, stageEvent{kind: stageFinish, p: stageParams(60)})
+	sr.handle(ctx, stageEvent{kind: stageStart, p: stageParams(0)}) // the buffer is running
+	sr.handle(ctx, stageEvent{kind: stageLeave, p: stageParams(3)})
+	sr.handle(ctx, stageEvent{kind: stageLeave, p: stageParams(3)})
+	want := []string{
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartReplayBuffer",
+		"SaveReplayBuffer", "ReplayBufferSaved", "SetProfileParameter %CCYY",
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartReplayBuffer",
+		"SetProfileParameter %CCYY", "StopReplayBuffer",
+	}
+	if got := f.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests\n%q\nwant\n%q", got, want)
+	}
+}
+
+// A buffer started before the stage recorder is left running.
+func TestStageRecorderRunningBuffer(t *testing.T) {
+	sr, f := testStageRecorder(t, RecordReplayBuffer)
+	f.mu.Lock()
+	f.buffering = true
+	f.mu.Unlock()
+	ctx := testContext(t)
+	sr.handle(ctx, stageEvent{kind: stageStart, p: stageParams(0)})
+	sr.handle(ctx, stageEvent{kind: stageLeave, p: stageParams(3)})
+	want := []string{
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartReplayBuffer",
+		"SetProfileParameter %CCYY",
+	}
+	if got := f.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestStageRecorderShutdown(t *testing.T) {
+	sr, f := testStageRecorder(t, RecordReplayBuffer)
+	sr.handle(testContext(t), stageEvent{kind: stageStart, p: stageParams(0)})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { sr.Run(ctx); close(done) }()
+	cancel()
+	<-done
+	want := []string{
+		"GetProfileParameter", "SetProfileParameter stage1000m", "StartReplayBuffer",
+		"SetProfileParameter %CCYY", "StopReplayBuffer",
+	}
+	if got := f.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests\n%q\nwant\n%q", got, want)
+	}
+}
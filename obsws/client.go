@@ -0,0 +1,372 @@
+// Package obsws is a client of obs-websocket v5, the remote control protocol of OBS Studio.
+//
+// Only what the server needs is implemented: connection with authentication,
+// requests, request batches and events, over a minimal WebSocket client
+// for text messages. Client.Run serves a single connection, callers reconnect
+// by running it again.
+package obsws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocol is the WebSocket subprotocol of obs-websocket with JSON messages.
+const Protocol = "obswebsocket.json"
+
+const rpcVersion = 1
+
+// message opcodes
+const (
+	opHello                = 0
+	opIdentify             = 1
+	opIdentified           = 2
+	opEvent                = 5
+	opRequest              = 6
+	opRequestResponse      = 7
+	opRequestBatch         = 8
+	opRequestBatchResponse = 9
+)
+
+// Event subscriptions, combined in Client.Events.
+const (
+	EventGeneral = 1 << 0
+	EventConfig  = 1 << 1
+	EventScenes  = 1 << 2
+	EventInputs  = 1 << 3
+	EventOutputs = 1 << 6
+	EventUi      = 1 << 10
+)
+
+// ErrNotConnected is returned by requests while the client is not identified.
+var ErrNotConnected = errors.New("obsws: not connected")
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type hello struct {
+	ObsWebSocketVersion string `json:"obsWebSocketVersion"`
+	RpcVersion          int    `json:"rpcVersion"`
+	Authentication      *struct {
+		Challenge string `json:"challenge"`
+		Salt      string `json:"salt"`
+	} `json:"authentication"`
+}
+
+type identify struct {
+	RpcVersion         int    `json:"rpcVersion"`
+	Authentication     string `json:"authentication,omitempty"`
+	EventSubscriptions uint32 `json:"eventSubscriptions"`
+}
+
+type event struct {
+	EventType string          `json:"eventType"`
+	EventData json.RawMessage `json:"eventData"`
+}
+
+// Request is a request of a batch.
+type Request struct {
+	RequestType string          `json:"requestType"`
+	RequestID   string          `json:"requestId,omitempty"`
+	RequestData json.RawMessage `json:"requestData,omitempty"`
+}
+
+type RequestStatus struct {
+	Result  bool   `json:"result"`
+	Code    int    `json:"code"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Response is the response of a request.
+type Response struct {
+	RequestType   string          `json:"requestType"`
+	RequestID     string          `json:"requestId"`
+	RequestStatus RequestStatus   `json:"requestStatus"`
+	ResponseData  json.RawMessage `json:"responseData,omitempty"`
+}
+
+// Err returns the failure of the request as *RequestError, nil on success.
+func (r *Response) Err() error {
+	if r.RequestStatus.Result {
+		return nil
+	}
+	return &RequestError{Type: r.RequestType, Code: r.RequestStatus.Code, Comment: r.RequestStatus.Comment}
+}
+
+type RequestError struct {
+	Type    string
+	Code    int
+	Comment string
+}
+
+func (e *RequestError) Error() string {
+	if e.Comment == "" {
+		return fmt.Sprintf("obsws: %s failed: %d", e.Type, e.Code)
+	}
+	return fmt.Sprintf("obsws: %s failed: %d %s", e.Type, e.Code, e.Comment)
+}
+
+type batch struct {
+	RequestID     string    `json:"requestId"`
+	HaltOnFailure bool      `json:"haltOnFailure"`
+	Requests      []Request `json:"requests"`
+}
+
+type batchResponse struct {
+	RequestID string     `json:"requestId"`
+	Results   []Response `json:"results"`
+}
+
+// Client is an obs-websocket v5 client, requests fail with ErrNotConnected
+// unless Run is connected.
+type Client struct {
+	URL      string // e.g. ws://127.0.0.1:4455
+	Password string // empty if authentication is disabled
+	Events   uint32 // event subscriptions, e.g. EventOutputs
+
+	// OnEvent is called from the read loop for subscribed events.
+	OnEvent func(eventType string, data json.RawMessage)
+	// OnConnect is called in its own goroutine once identified.
+	OnConnect func()
+
+	mu      sync.Mutex
+	conn    *conn
+	pending map[string]chan json.RawMessage
+	nextID  uint64
+}
+
+// Run connects, identifies and serves the connection until it fails or ctx is done.
+func (c *Client) Run(ctx context.Context) error {
+	dctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ws, err := dial(dctx, c.URL, Protocol)
+	if err != nil {
+		cancel()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { ws.close() })
+	defer stop()
+	defer ws.close()
+	err = c.identify(dctx, ws)
+	cancel()
+	if err != nil {
+		return err
+	}
+	log.Print("obs connected: ", c.URL)
+	c.mu.Lock()
+	c.conn, c.pending = ws, map[string]chan json.RawMessage{}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		log.Print("obs disconnected: ", c.URL)
+	}()
+	if c.OnConnect != nil {
+		go c.OnConnect()
+	}
+	for {
+		b, err := ws.readMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var m message
+		if err := json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("obsws: %w", err)
+		}
+		switch m.Op {
+		case opEvent:
+			var ev event
+			if err := json.Unmarshal(m.D, &ev); err == nil && c.OnEvent != nil {
+				c.OnEvent(ev.EventType, ev.EventData)
+			}
+		case opRequestResponse, opRequestBatchResponse:
+			var id struct {
+				RequestID string `json:"requestId"`
+			}
+			if err := json.Unmarshal(m.D, &id); err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch := c.pending[id.RequestID]
+			delete(c.pending, id.RequestID)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- m.D
+			}
+		}
+	}
+}
+
+// identify answers the Hello of the server, authenticating with Password.
+func (c *Client) identify(ctx context.Context, ws *conn) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.handshake(ws)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		ws.close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (c *Client) handshake(ws *conn) error {
+	m, err := readOp(ws, opHello)
+	if err != nil {
+		return err
+	}
+	var h hello
+	if err := json.Unmarshal(m.D, &h); err != nil {
+		return fmt.Errorf("obsws: hello: %w", err)
+	}
+	id := identify{RpcVersion: rpcVersion, EventSubscriptions: c.Events}
+	if h.Authentication != nil {
+		if c.Password == "" {
+			return errors.New("obsws: the server requires a password")
+		}
+		id.Authentication = auth(c.Password, h.Authentication.Salt, h.Authentication.Challenge)
+	}
+	if err := writeOp(ws, opIdentify, id); err != nil {
+		return err
+	}
+	_, err = readOp(ws, opIdentified)
+	return err
+}
+
+// auth computes the authentication string from the salt and challenge of the Hello.
+func auth(password, salt, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	s := base64.StdEncoding.EncodeToString(secret[:])
+	sum := sha256.Sum256([]byte(s + challenge))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func readOp(ws *conn, op int) (message, error) {
+	b, err := ws.readMessage()
+	if err != nil {
+		return message{}, err
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return message{}, fmt.Errorf("obsws: %w", err)
+	}
+	if m.Op != op {
+		return message{}, fmt.Errorf("obsws: unexpected op %d, want %d", m.Op, op)
+	}
+	return m, nil
+}
+
+func writeOp(ws *conn, op int, d any) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(message{Op: op, D: b})
+	if err != nil {
+		return err
+	}
+	return ws.writeText(b)
+}
+
+// Connected reports whether the client is identified.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// call sends d with a new request id and waits for its response.
+func (c *Client) call(ctx context.Context, op int, d func(id string) any) (json.RawMessage, error) {
+	c.mu.Lock()
+	ws := c.conn
+	if ws == nil {
+		c.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	ch := make(chan json.RawMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	if err := writeOp(ws, op, d(id)); err != nil {
+		c.cancel(id)
+		return nil, err
+	}
+	select {
+	case b, ok := <-ch:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return b, nil
+	case <-ctx.Done():
+		c.cancel(id)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) cancel(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// Request sends a request, data is marshaled as requestData unless nil,
+// and returns its responseData.
+func (c *Client) Request(ctx context.Context, requestType string, data any) (json.RawMessage, error) {
+	req := Request{RequestType: requestType}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		req.RequestData = b
+	}
+	b, err := c.call(ctx, opRequest, func(id string) any {
+		req.RequestID = id
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("obsws: %w", err)
+	}
+	return resp.ResponseData, resp.Err()
+}
+
+// Batch sends requests as a single batch executed in order, stopping at the
+// first failure if haltOnFailure is set, and returns the responses of the executed ones.
+func (c *Client) Batch(ctx context.Context, haltOnFailure bool, reqs ...Request) ([]Response, error) {
+	b, err := c.call(ctx, opRequestBatch, func(id string) any {
+		return batch{RequestID: id, HaltOnFailure: haltOnFailure, Requests: reqs}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp batchResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("obsws: %w", err)
+	}
+	return resp.Results, nil
+}
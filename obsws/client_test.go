@@ -0,0 +1,134 @@
+package obsws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFakeOBS(t *testing.T, password string) (*FakeServer, string) {
+	s := NewFakeServer(password)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// connect runs c until the test ends and waits until it is identified.
+func connect(t *testing.T, c *Client) {
+	connected := make(chan struct{})
+	c.OnConnect = func() { close(connected) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	select {
+	case <-connected:
+	case err := <-done:
+		t.Fatalf("Run: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected")
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestAuthentication(t *testing.T) {
+	_, url := newFakeOBS(t, "secret")
+	c := &Client{URL: url, Password: "secret"}
+	connect(t, c)
+	if !c.Connected() {
+		t.Error("not connected")
+	}
+}
+
+func TestAuthenticationFailure(t *testing.T) {
+	_, url := newFakeOBS(t, "secret")
+	err := (&Client{URL: url, Password: "wrong"}).Run(testContext(t))
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != 4009 {
+		t.Errorf("wrong password: %v, want close 4009", err)
+	}
+	if err := (&Client{URL: url}).Run(testContext(t)); err == nil {
+		t.Error("no password: no error")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	_, url := newFakeOBS(t, "")
+	c := &Client{URL: url}
+	if _, err := c.Request(testContext(t), "GetVersion", nil); err != ErrNotConnected {
+		t.Errorf("before Run: %v, want ErrNotConnected", err)
+	}
+	connect(t, c)
+	b, err := c.Request(testContext(t), "SetCurrentProgramScene", map[string]string{"sceneName": "playing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		SceneName string `json:"sceneName"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil || data.SceneName != "playing" {
+		t.Errorf("response data: %s", b)
+	}
+	_, err = c.Request(testContext(t), "Fail", nil)
+	var re *RequestError
+	if !errors.As(err, &re) || re.Type != "Fail" || re.Code != 600 || re.Comment != "failed on purpose" {
+		t.Errorf("failed request: %v", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	_, url := newFakeOBS(t, "")
+	c := &Client{URL: url}
+	connect(t, c)
+	reqs := []Request{
+		{RequestType: "StartRecord"},
+		{RequestType: "Fail"},
+		{RequestType: "StopRecord"},
+	}
+	res, err := c.Batch(testContext(t), true, reqs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Err() != nil || res[1].Err() == nil {
+		t.Errorf("halted batch: %+v", res)
+	}
+	res, err = c.Batch(testContext(t), false, reqs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || res[2].RequestType != "StopRecord" || res[2].Err() != nil {
+		t.Errorf("batch: %+v", res)
+	}
+}
+
+func TestEvent(t *testing.T) {
+	s, url := newFakeOBS(t, "")
+	events := make(chan event, 1)
+	c := &Client{URL: url, Events: EventOutputs, OnEvent: func(eventType string, data json.RawMessage) {
+		events <- event{EventType: eventType, EventData: data}
+	}}
+	connect(t, c)
+	s.Event("RecordStateChanged", map[string]bool{"outputActive": false})
+	select {
+	case ev := <-events:
+		if ev.EventType != "RecordStateChanged" || string(ev.EventData) != `{"outputActive":false}` {
+			t.Errorf("event: %s %s", ev.EventType, ev.EventData)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+}
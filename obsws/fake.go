@@ -0,0 +1,182 @@
+package obsws
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+)
+
+// FakeServer is an obs-websocket v5 server for the tests of clients, served
+// with httptest. It answers the requests with Respond, FakeResponse if nil,
+// and drops the connection on protocol errors, the client tests report them.
+type FakeServer struct {
+	Password string // authentication is disabled if empty
+	Respond  func(req Request) Response
+	events   chan event
+}
+
+const (
+	testSalt      = "lM1GncleQOaCu9lT1yeUZhFYnqhsLLP1G5lAGo3ixaI="
+	testChallenge = "+IxH4CnCiqpX1rM9scsNynZzbOe4KhDeYcTNS3PDaeY="
+)
+
+// NewFakeServer returns a server with the password, empty to disable authentication.
+func NewFakeServer(password string) *FakeServer {
+	return &FakeServer{Password: password, events: make(chan event, 4)}
+}
+
+// Event sends an event with data marshaled to JSON to the connected client.
+func (s *FakeServer) Event(eventType string, data any) {
+	b, _ := json.Marshal(data)
+	s.events <- event{EventType: eventType, EventData: b}
+}
+
+// serverConn writes unmasked frames, reads with the client code accepting masked ones.
+type serverConn struct {
+	*conn
+}
+
+func (s serverConn) send(op int, d any) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(message{Op: op, D: b})
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(opText, b)
+}
+
+func (s serverConn) writeFrame(op byte, p []byte) error {
+	b := []byte{0x80 | op}
+	switch n := len(p); {
+	case n < 126:
+		b = append(b, byte(n))
+	case n <= 0xffff:
+		b = append(b, 126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	_, err := s.c.Write(append(b, p...))
+	return err
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Protocol") != Protocol {
+		http.Error(w, "not an obs-websocket request", http.StatusBadRequest)
+		return
+	}
+	nc, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Protocol: " + Protocol + "\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	brw.Flush()
+	s.serve(serverConn{&conn{c: nc, br: brw.Reader}})
+}
+
+func (s *FakeServer) serve(ws serverConn) error {
+	h := map[string]any{"obsWebSocketVersion": "5.0.0", "rpcVersion": rpcVersion}
+	if s.Password != "" {
+		h["authentication"] = map[string]string{"challenge": testChallenge, "salt": testSalt}
+	}
+	if err := ws.send(opHello, h); err != nil {
+		return err
+	}
+	m, err := readOp(ws.conn, opIdentify)
+	if err != nil {
+		return err
+	}
+	var id identify
+	if err := json.Unmarshal(m.D, &id); err != nil {
+		return err
+	}
+	if s.Password != "" {
+		// computed as documented by obs-websocket, independently of auth
+		secret := sha256.Sum256([]byte(s.Password + testSalt))
+		sum := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + testChallenge))
+		if id.Authentication != base64.StdEncoding.EncodeToString(sum[:]) {
+			return ws.writeFrame(opClose, append(binary.BigEndian.AppendUint16(nil, 4009), "Authentication failed."...))
+		}
+	}
+	if err := ws.send(opIdentified, map[string]int{"negotiatedRpcVersion": rpcVersion}); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case ev := <-s.events:
+				ws.send(opEvent, map[string]any{"eventType": ev.EventType, "eventIntent": id.EventSubscriptions, "eventData": ev.EventData})
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		b, err := ws.readMessage()
+		if err != nil {
+			return err
+		}
+		var m message
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		switch m.Op {
+		case opRequest:
+			var req Request
+			if err := json.Unmarshal(m.D, &req); err != nil {
+				return err
+			}
+			if err := ws.send(opRequestResponse, s.respond(req)); err != nil {
+				return err
+			}
+		case opRequestBatch:
+			var req batch
+			if err := json.Unmarshal(m.D, &req); err != nil {
+				return err
+			}
+			res := batchResponse{RequestID: req.RequestID, Results: []Response{}}
+			for _, r := range req.Requests {
+				resp := s.respond(r)
+				res.Results = append(res.Results, resp)
+				if !resp.RequestStatus.Result && req.HaltOnFailure {
+					break
+				}
+			}
+			if err := ws.send(opRequestBatchResponse, res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *FakeServer) respond(req Request) Response {
+	if s.Respond != nil {
+		return s.Respond(req)
+	}
+	return FakeResponse(req)
+}
+
+// FakeResponse answers req with its requestData as responseData,
+// requests of type "Fail" fail with code 600.
+func FakeResponse(req Request) Response {
+	resp := Response{RequestType: req.RequestType, RequestID: req.RequestID, RequestStatus: RequestStatus{Result: true, Code: 100}}
+	if req.RequestType == "Fail" {
+		resp.RequestStatus = RequestStatus{Code: 600, Comment: "failed on purpose"}
+		return resp
+	}
+	resp.ResponseData = req.RequestData
+	return resp
+}
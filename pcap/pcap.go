@@ -0,0 +1,341 @@
+// Package pcap extracts UDP datagrams from pcap and pcapng capture files,
+// e.g. saved by Wireshark or tcpdump.
+//
+// Only what telemetry traffic needs is supported: Ethernet (with VLAN tags),
+// Linux cooked (SLL, SLL2), BSD loopback and raw IP link types,
+// unfragmented IPv4 and IPv6. Other packets are skipped.
+// pcapng simple packet blocks have no timestamp, they get the time of the
+// previous packet.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"time"
+)
+
+const (
+	magicMicro   = 0xa1b2c3d4
+	magicNano    = 0xa1b23c4d
+	blockSection = 0x0a0d0d0a
+	byteOrder    = 0x1a2b3c4d
+)
+
+// link types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// pcapng block types
+const (
+	blockInterface = 1
+	blockPacket    = 2 // obsolete
+	blockSimple    = 3
+	blockEnhanced  = 6
+)
+
+type Datagram struct {
+	Time    time.Time
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Payload []byte
+}
+
+type iface struct {
+	link uint32
+	res  uint64 // timestamp units per second
+}
+
+type Reader struct {
+	r      *bufio.Reader
+	ng     bool
+	order  binary.ByteOrder
+	ifaces []iface   // pcap files have a single interface
+	last   time.Time // time of the last packet, for simple packet blocks
+	buf    []byte
+}
+
+// NewReader detects the file type from its first block.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+	head, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("pcap: %w", err)
+	}
+	if binary.LittleEndian.Uint32(head) == blockSection {
+		pr.ng = true
+		return pr, nil
+	}
+	var h [24]byte
+	if _, err := io.ReadFull(pr.r, h[:]); err != nil {
+		return nil, fmt.Errorf("pcap: %w", err)
+	}
+	res := uint64(1e6)
+	switch {
+	case binary.LittleEndian.Uint32(h[:]) == magicMicro:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(h[:]) == magicMicro:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(h[:]) == magicNano:
+		pr.order, res = binary.LittleEndian, 1e9
+	case binary.BigEndian.Uint32(h[:]) == magicNano:
+		pr.order, res = binary.BigEndian, 1e9
+	default:
+		return nil, fmt.Errorf("pcap: not a pcap or pcapng file")
+	}
+	pr.ifaces = []iface{{link: pr.order.Uint32(h[20:24]) & 0xffff, res: res}}
+	return pr, nil
+}
+
+// Next returns the next UDP datagram, its Payload is valid until the next call.
+// It returns io.EOF at the end of the file.
+func (r *Reader) Next() (Datagram, error) {
+	for {
+		var (
+			d    Datagram
+			data []byte
+			ifc  iface
+			err  error
+		)
+		if r.ng {
+			d.Time, data, ifc, err = r.nextBlock()
+		} else {
+			d.Time, data, ifc, err = r.nextRecord()
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF // truncated last packet
+			}
+			return Datagram{}, err
+		}
+		if data == nil {
+			continue
+		}
+		if decode(&d, ifc.link, data) {
+			return d, nil
+		}
+	}
+}
+
+func (r *Reader) read(n int) ([]byte, error) {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	_, err := io.ReadFull(r.r, b)
+	return b, err
+}
+
+func (r *Reader) nextRecord() (time.Time, []byte, iface, error) {
+	ifc := r.ifaces[0]
+	b, err := r.read(16)
+	if err != nil {
+		return time.Time{}, nil, ifc, err
+	}
+	sec, frac := uint64(r.order.Uint32(b[0:4])), uint64(r.order.Uint32(b[4:8]))
+	t := timestamp(sec*ifc.res+frac, ifc.res)
+	n := int(r.order.Uint32(b[8:12]))
+	if n > 1<<24 {
+		return time.Time{}, nil, ifc, fmt.Errorf("pcap: invalid record size: %d", n)
+	}
+	b, err = r.read(n)
+	return t, b, ifc, err
+}
+
+func (r *Reader) nextBlock() (time.Time, []byte, iface, error) {
+	head, err := r.r.Peek(12)
+	if err != nil {
+		if len(head) > 0 && errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, nil, iface{}, err
+	}
+	if binary.LittleEndian.Uint32(head) == blockSection {
+		switch {
+		case binary.LittleEndian.Uint32(head[8:]) == byteOrder:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(head[8:]) == byteOrder:
+			r.order = binary.BigEndian
+		default:
+			return time.Time{}, nil, iface{}, fmt.Errorf("pcap: invalid section header")
+		}
+		r.ifaces = r.ifaces[:0]
+	}
+	typ, n := r.order.Uint32(head[0:4]), int(r.order.Uint32(head[4:8]))
+	if n < 12 || n%4 != 0 || n > 1<<24 {
+		return time.Time{}, nil, iface{}, fmt.Errorf("pcap: invalid block size: %d", n)
+	}
+	b, err := r.read(n)
+	if err != nil {
+		return time.Time{}, nil, iface{}, err
+	}
+	body := b[8 : n-4]
+	switch typ {
+	case blockInterface:
+		if len(body) < 8 {
+			return time.Time{}, nil, iface{}, fmt.Errorf("pcap: invalid interface block")
+		}
+		r.ifaces = append(r.ifaces, iface{
+			link: uint32(r.order.Uint16(body[0:2])),
+			res:  r.resolution(body[8:]),
+		})
+	case blockEnhanced, blockPacket:
+		if len(body) < 20 {
+			return time.Time{}, nil, iface{}, fmt.Errorf("pcap: invalid packet block")
+		}
+		id := int(r.order.Uint32(body[0:4]))
+		if typ == blockPacket {
+			id = int(r.order.Uint16(body[0:2]))
+		}
+		if id >= len(r.ifaces) {
+			return time.Time{}, nil, iface{}, fmt.Errorf("pcap: unknown interface: %d", id)
+		}
+		ifc := r.ifaces[id]
+		ts := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+		data := body[20:]
+		if l := int(r.order.Uint32(body[12:16])); l < len(data) {
+			data = data[:l]
+		}
+		r.last = timestamp(ts, ifc.res)
+		return r.last, data, ifc, nil
+	case blockSimple:
+		if len(body) < 4 || len(r.ifaces) == 0 {
+			return time.Time{}, nil, iface{}, fmt.Errorf("pcap: invalid simple packet block")
+		}
+		data := body[4:]
+		if l := int(r.order.Uint32(body[0:4])); l < len(data) {
+			data = data[:l]
+		}
+		return r.last, data, r.ifaces[0], nil // no timestamp, the time of the previous packet
+	}
+	return time.Time{}, nil, iface{}, nil
+}
+
+// resolution returns the if_tsresol option of interface block options.
+func (r *Reader) resolution(opts []byte) uint64 {
+	for len(opts) >= 4 {
+		code, l := r.order.Uint16(opts[0:2]), int(r.order.Uint16(opts[2:4]))
+		if code == 0 || 4+l > len(opts) {
+			break
+		}
+		if code == 9 && l == 1 {
+			v := opts[4]
+			if v&0x80 != 0 {
+				if v &= 0x7f; v < 64 {
+					return 1 << v
+				}
+			} else if v <= 19 {
+				res := uint64(1)
+				for ; v > 0; v-- {
+					res *= 10
+				}
+				return res
+			}
+		}
+		opts = opts[4+(l+3)&^3:]
+	}
+	return 1e6
+}
+
+func timestamp(ts, res uint64) time.Time {
+	sec, frac := ts/res, ts%res
+	hi, lo := bits.Mul64(frac, 1e9)
+	nsec, _ := bits.Div64(hi, lo, res)
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// decode fills d from a link layer frame, it reports false for non UDP packets.
+func decode(d *Datagram, link uint32, b []byte) bool {
+	switch link {
+	case linkEthernet:
+		if len(b) < 14 {
+			return false
+		}
+		typ, b2 := binary.BigEndian.Uint16(b[12:14]), b[14:]
+		for (typ == 0x8100 || typ == 0x88a8) && len(b2) >= 4 { // VLAN tags
+			typ, b2 = binary.BigEndian.Uint16(b2[2:4]), b2[4:]
+		}
+		if typ != 0x0800 && typ != 0x86dd {
+			return false
+		}
+		b = b2
+	case linkNull, linkLoop:
+		if len(b) < 4 {
+			return false
+		}
+		b = b[4:]
+	case linkSLL:
+		if len(b) < 16 {
+			return false
+		}
+		b = b[16:]
+	case linkSLL2:
+		if len(b) < 20 {
+			return false
+		}
+		b = b[20:]
+	case linkRaw, linkIPv4, linkIPv6:
+	default:
+		return false
+	}
+	if len(b) == 0 {
+		return false
+	}
+	var src, dst netip.Addr
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return false
+		}
+		hl, total := int(b[0]&0xf)*4, int(binary.BigEndian.Uint16(b[2:4]))
+		if binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 || b[9] != 17 || hl < 20 || total < hl || total > len(b) {
+			return false // fragmented, not UDP or truncated
+		}
+		src, dst = netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+		b = b[hl:total]
+	case 6:
+		if len(b) < 40 {
+			return false
+		}
+		next, total := b[6], 40+int(binary.BigEndian.Uint16(b[4:6]))
+		if total > len(b) {
+			return false
+		}
+		src, dst = netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+		b = b[40:total]
+		for next == 0 || next == 43 || next == 60 { // hop-by-hop, routing, destination options
+			if len(b) < 8 || len(b) < (int(b[1])+1)*8 {
+				return false
+			}
+			next, b = b[0], b[(int(b[1])+1)*8:]
+		}
+		if next != 17 {
+			return false
+		}
+	default:
+		return false
+	}
+	if len(b) < 8 {
+		return false
+	}
+	l := int(binary.BigEndian.Uint16(b[4:6]))
+	if l < 8 || l > len(b) {
+		return false
+	}
+	d.Src = netip.AddrPortFrom(src, binary.BigEndian.Uint16(b[0:2]))
+	d.Dst = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2:4]))
+	d.Payload = b[8:l]
+	return true
+}
@@ -0,0 +1,296 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var (
+	src4    = netip.MustParseAddrPort("192.0.2.1:20777")
+	dst4    = netip.MustParseAddrPort("192.0.2.2:20778")
+	src6    = netip.MustParseAddrPort("[2001:db8::1]:20777")
+	dst6    = netip.MustParseAddrPort("[2001:db8::2]:20778")
+	payload = []byte("telemetry")
+)
+
+func udp(src, dst netip.AddrPort, p []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+	b = binary.BigEndian.AppendUint16(b, uint16(8+len(p)))
+	return append(append(b, 0, 0), p...)
+}
+
+// ipv4 returns an IPv4 packet with the fragment field flags and protocol proto.
+func ipv4(flags uint16, proto byte, body []byte) []byte {
+	b := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, proto, 0, 0}
+	binary.BigEndian.PutUint16(b[2:4], uint16(20+len(body)))
+	binary.BigEndian.PutUint16(b[6:8], flags)
+	b = append(b, src4.Addr().AsSlice()...)
+	b = append(b, dst4.Addr().AsSlice()...)
+	return append(b, body...)
+}
+
+// ipv6 returns an IPv6 packet, with a hop-by-hop options header if hop is set.
+func ipv6(hop bool, body []byte) []byte {
+	next := byte(17)
+	if hop {
+		body = append([]byte{17, 0, 0, 0, 0, 0, 0, 0}, body...)
+		next = 0
+	}
+	b := []byte{0x60, 0, 0, 0, 0, 0, next, 64}
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(body)))
+	b = append(b, src6.Addr().AsSlice()...)
+	b = append(b, dst6.Addr().AsSlice()...)
+	return append(b, body...)
+}
+
+func udp4() []byte { return ipv4(0x4000, 17, udp(src4, dst4, payload)) } // don't fragment
+func udp6() []byte { return ipv6(false, udp(src6, dst6, payload)) }
+
+func ether(tags []uint16, typ uint16, b []byte) []byte {
+	h := make([]byte, 12)
+	for _, tag := range tags {
+		h = binary.BigEndian.AppendUint16(h, tag)
+		h = append(h, 0, 1) // VLAN id 1
+	}
+	return append(binary.BigEndian.AppendUint16(h, typ), b...)
+}
+
+// pcapFile returns a pcap file of one interface with a record per frame,
+// frame i is at i+1.5 seconds.
+func pcapFile(order binary.AppendByteOrder, nano bool, link uint32, frames ...[]byte) []byte {
+	magic, frac := uint32(magicMicro), uint32(500000)
+	if nano {
+		magic, frac = magicNano, 500000000
+	}
+	b := order.AppendUint32(nil, magic)
+	b = order.AppendUint16(b, 2)
+	b = order.AppendUint16(b, 4)
+	b = append(b, make([]byte, 8)...)
+	b = order.AppendUint32(b, 65535)
+	b = order.AppendUint32(b, link)
+	for i, f := range frames {
+		b = order.AppendUint32(b, uint32(i+1))
+		b = order.AppendUint32(b, frac)
+		b = order.AppendUint32(b, uint32(len(f)))
+		b = order.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func block(order binary.AppendByteOrder, typ uint32, body []byte) []byte {
+	body = append(body, make([]byte, -len(body)&3)...)
+	n := uint32(12 + len(body))
+	b := order.AppendUint32(nil, typ)
+	b = order.AppendUint32(b, n)
+	return order.AppendUint32(append(b, body...), n)
+}
+
+func shb(order binary.AppendByteOrder) []byte {
+	b := order.AppendUint32(nil, byteOrder)
+	b = order.AppendUint16(b, 1)
+	b = order.AppendUint16(b, 0)
+	b = order.AppendUint64(b, ^uint64(0)) // unknown section length
+	return block(order, blockSection, b)
+}
+
+// idb returns an interface block, with an if_tsresol option unless tsresol is 0.
+func idb(order binary.AppendByteOrder, link uint16, tsresol byte) []byte {
+	b := order.AppendUint16(nil, link)
+	b = order.AppendUint16(b, 0)
+	b = order.AppendUint32(b, 65535)
+	if tsresol != 0 {
+		b = order.AppendUint16(b, 9)
+		b = order.AppendUint16(b, 1)
+		b = append(b, tsresol, 0, 0, 0)
+		b = append(b, 0, 0, 0, 0) // opt_endofopt
+	}
+	return block(order, blockInterface, b)
+}
+
+func epb(order binary.AppendByteOrder, ifc uint32, ts uint64, data []byte) []byte {
+	b := order.AppendUint32(nil, ifc)
+	b = order.AppendUint32(b, uint32(ts>>32))
+	b = order.AppendUint32(b, uint32(ts))
+	b = order.AppendUint32(b, uint32(len(data)))
+	b = order.AppendUint32(b, uint32(len(data)))
+	return block(order, blockEnhanced, append(b, data...))
+}
+
+func spb(order binary.AppendByteOrder, data []byte) []byte {
+	return block(order, blockSimple, append(order.AppendUint32(nil, uint32(len(data))), data...))
+}
+
+func readAll(t *testing.T, b []byte) ([]Datagram, error) {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var res []Datagram
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		d.Payload = append([]byte(nil), d.Payload...)
+		res = append(res, d)
+	}
+}
+
+func TestLinkTypes(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		link  uint32
+		frame []byte
+		src   netip.AddrPort // invalid if the packet is skipped
+	}{
+		{"ethernet", linkEthernet, ether(nil, 0x0800, udp4()), src4},
+		{"vlan", linkEthernet, ether([]uint16{0x8100}, 0x0800, udp4()), src4},
+		{"qinq", linkEthernet, ether([]uint16{0x88a8, 0x8100}, 0x86dd, udp6()), src6},
+		{"ethernet ipv6", linkEthernet, ether(nil, 0x86dd, udp6()), src6},
+		{"ipv6 hop-by-hop", linkEthernet, ether(nil, 0x86dd, ipv6(true, udp(src6, dst6, payload))), src6},
+		{"null", linkNull, append([]byte{2, 0, 0, 0}, udp4()...), src4},
+		{"loopback", linkLoop, append([]byte{0, 0, 0, 2}, udp4()...), src4},
+		{"sll", linkSLL, append(make([]byte, 16), udp4()...), src4},
+		{"sll2", linkSLL2, append(make([]byte, 20), udp6()...), src6},
+		{"raw", linkRaw, udp4(), src4},
+		{"ipv4", linkIPv4, udp4(), src4},
+		{"ipv6", linkIPv6, udp6(), src6},
+		{"arp", linkEthernet, ether(nil, 0x0806, make([]byte, 28)), netip.AddrPort{}},
+		{"fragment", linkRaw, ipv4(0x2000, 17, udp(src4, dst4, payload)), netip.AddrPort{}},
+		{"fragment offset", linkRaw, ipv4(0x0010, 17, udp(src4, dst4, payload)), netip.AddrPort{}},
+		{"tcp", linkRaw, ipv4(0, 6, make([]byte, 20)), netip.AddrPort{}},
+		{"truncated ipv4", linkRaw, udp4()[:30], netip.AddrPort{}},
+		{"truncated ipv6", linkRaw, udp6()[:50], netip.AddrPort{}},
+		{"truncated udp", linkRaw, ipv4(0, 17, udp(src4, dst4, payload)[:6]), netip.AddrPort{}},
+		{"short ethernet", linkEthernet, make([]byte, 10), netip.AddrPort{}},
+		{"unknown link", 147, udp4(), netip.AddrPort{}},
+	} {
+		ds, err := readAll(t, pcapFile(binary.LittleEndian, false, c.link, c.frame))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !c.src.IsValid() {
+			if len(ds) != 0 {
+				t.Errorf("%s: %d datagrams, want none", c.name, len(ds))
+			}
+			continue
+		}
+		dst := dst4
+		if c.src == src6 {
+			dst = dst6
+		}
+		if len(ds) != 1 || ds[0].Src != c.src || ds[0].Dst != dst || !bytes.Equal(ds[0].Payload, payload) {
+			t.Errorf("%s: %+v", c.name, ds)
+		}
+	}
+}
+
+func TestPcapByteOrders(t *testing.T) {
+	want := time.Unix(1, 500000000)
+	for _, c := range []struct {
+		name  string
+		order binary.AppendByteOrder
+		nano  bool
+	}{
+		{"little endian", binary.LittleEndian, false},
+		{"big endian", binary.BigEndian, false},
+		{"little endian ns", binary.LittleEndian, true},
+		{"big endian ns", binary.BigEndian, true},
+	} {
+		ds, err := readAll(t, pcapFile(c.order, c.nano, linkRaw, udp4(), udp4()))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(ds) != 2 || !ds[0].Time.Equal(want) || !ds[1].Time.Equal(want.Add(time.Second)) {
+			t.Errorf("%s: %+v", c.name, ds)
+		}
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var b []byte
+		b = append(b, shb(order)...)
+		b = append(b, idb(order, linkEthernet, 0)...)      // microseconds
+		b = append(b, idb(order, linkRaw, 9)...)           // nanoseconds
+		b = append(b, idb(order, linkRaw, 0x80|10)...)     // 1/1024 seconds
+		b = append(b, block(order, 5, make([]byte, 8))...) // skipped statistics block
+		b = append(b, epb(order, 0, 1500000, ether(nil, 0x0800, udp4()))...)
+		b = append(b, spb(order, ether(nil, 0x0800, udp4()))...)
+		b = append(b, epb(order, 1, 2500000000, udp6())...)
+		b = append(b, epb(order, 2, 3*1024+512, udp4())...)
+		ds, err := readAll(t, b)
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		want := []time.Time{
+			time.Unix(1, 500000000),
+			time.Unix(1, 500000000), // simple packet block, time of the previous packet
+			time.Unix(2, 500000000),
+			time.Unix(3, 500000000),
+		}
+		if len(ds) != len(want) {
+			t.Fatalf("%v: %d datagrams, want %d", order, len(ds), len(want))
+		}
+		for i, d := range ds {
+			if !d.Time.Equal(want[i]) || !bytes.Equal(d.Payload, payload) {
+				t.Errorf("%v: datagram %d: time %v payload %q", order, i, d.Time, d.Payload)
+			}
+		}
+		if ds[2].Src != src6 {
+			t.Errorf("%v: source %v, want %v", order, ds[2].Src, src6)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	le := binary.LittleEndian
+	for _, c := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"not a capture", bytes.Repeat([]byte{0x42}, 24)},
+		{"short header", pcapFile(le, false, linkRaw)[:10]},
+		{"oversized record", append(pcapFile(le, false, linkRaw), 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 2)},
+		{"invalid block size", append(shb(le), 6, 0, 0, 0, 13, 0, 0, 0, 0, 0, 0, 0)},
+		{"invalid section header", block(le, blockSection, make([]byte, 16))},
+		{"unknown interface", append(append(shb(le), idb(le, linkRaw, 0)...), epb(le, 1, 0, udp4())...)},
+		{"simple packet without interface", append(shb(le), spb(le, udp4())...)},
+		{"short interface block", append(shb(le), block(le, blockInterface, make([]byte, 4))...)},
+		{"short packet block", append(append(shb(le), idb(le, linkRaw, 0)...), block(le, blockEnhanced, make([]byte, 8))...)},
+	} {
+		if _, err := readAll(t, c.b); err == nil {
+			t.Errorf("%s: no error", c.name)
+		}
+	}
+}
+
+// A truncated last packet ends the file.
+func TestTruncated(t *testing.T) {
+	le := binary.LittleEndian
+	for _, c := range []struct {
+		name string
+		b    []byte
+	}{
+		{"pcap record", pcapFile(le, false, linkRaw, udp4(), udp4())},
+		{"pcapng block", append(append(shb(le), idb(le, linkRaw, 0)...), append(epb(le, 0, 0, udp4()), epb(le, 0, 0, udp4())...)...)},
+	} {
+		ds, err := readAll(t, c.b[:len(c.b)-10])
+		if err != nil || len(ds) != 1 {
+			t.Errorf("%s: %d datagrams, %v", c.name, len(ds), err)
+		}
+	}
+}
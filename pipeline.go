@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Pipeline is a named source with its own profiles and per driver state,
+// overlays select it with ?source=name.
+type Pipeline struct {
+	Name     string
+	Source   Source
+	Live     bool          // record and relay the raw datagrams
+	Timeout  time.Duration // a driver without packets for Timeout is deactivated
+	Profiles codemasters.Profiles
+	recorder *Recorder // nil unless RECORD_DIR is set
+
+	mu       sync.Mutex
+	drivers  map[string]*Driver
+	decoders map[string]*codemasters.Decoder // per driver, dropped with the driver
+	names    map[netip.AddrPort]string       // driver names by sender, dropped with the driver
+	stats    SequenceStats                   // all drivers
+}
+
+// newPipeline creates a live pipeline with PROFILES and TIMEOUT overridden by PROFILES_<NAME> and TIMEOUT_<NAME>.
+func newPipeline(name string, src Source, recordDir string) (*Pipeline, error) {
+	pl := &Pipeline{Name: name, Source: src, Live: true, Timeout: config.Timeout, Profiles: codemasters.Profiles{},
+		drivers: map[string]*Driver{}, decoders: map[string]*codemasters.Decoder{}, names: map[netip.AddrPort]string{}}
+	for f, p := range config.Profiles {
+		pl.Profiles[f] = p
+	}
+	key := "PROFILES_" + strings.ToUpper(name)
+	if v, ok := os.LookupEnv(key); ok {
+		var ps codemasters.Profiles
+		if err := ps.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		for f, p := range ps {
+			pl.Profiles[f] = p
+		}
+	}
+	key = "TIMEOUT_" + strings.ToUpper(name)
+	if v, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid timeout: %q", key, v)
+		}
+		pl.Timeout = d
+	}
+	if recordDir != "" {
+		pl.recorder = NewRecorder(recordDir)
+	}
+	return pl, nil
+}
+
+// newPipelines creates the SOURCES, or a single "default" source on LISTEN_UDP.
+// Named sources record into a subdirectory of RECORD_DIR.
+func newPipelines() ([]*Pipeline, error) {
+	if len(config.Sources) == 0 {
+		pl, err := newPipeline("default", &UDPSource{Listen: config.Listen}, config.RecordDir)
+		if err != nil {
+			return nil, err
+		}
+		return []*Pipeline{pl}, nil
+	}
+	var res []*Pipeline
+	for _, c := range config.Sources {
+		dir := ""
+		if config.RecordDir != "" {
+			dir = filepath.Join(config.RecordDir, c.Name)
+		}
+		pl, err := newPipeline(c.Name, &UDPSource{Listen: c.Listen}, dir)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pl)
+	}
+	return res, nil
+}
+
+// findPipeline returns the pipeline named name, the first one if name is empty.
+func findPipeline(name string) *Pipeline {
+	if name == "" && len(pipelines) > 0 {
+		return pipelines[0]
+	}
+	for _, pl := range pipelines {
+		if pl.Name == name {
+			return pl
+		}
+	}
+	return nil
+}
+
+// Run feeds the source into the pipeline and publishes to ch until ctx is done.
+func (pl *Pipeline) Run(ctx context.Context, ch chan<- Params) error {
+	log.Printf("source %s: %s", pl.Name, pl.Source)
+	return pl.Source.Run(ctx, &receiver{ch: ch, pl: pl})
+}
+
+// receiver is the Sink of a pipeline, it updates the per driver state and publishes it.
+type receiver struct {
+	ch chan<- Params
+	pl *Pipeline
+}
+
+// Decode looks up the driver name of the sender once, and keeps the decoder
+// and the name of a sender only once it sent a valid packet.
+func (r *receiver) Decode(p *Packet) {
+	pl := r.pl
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	name, named := pl.names[p.Origin]
+	if !named {
+		name = config.Drivers.Name(p.Origin)
+	}
+	d, ok := pl.decoders[name]
+	if !ok {
+		d = codemasters.NewDecoder(config.Index)
+	}
+	p.Driver = name
+	p.Telemetry, p.Format, p.Err = d.Decode(p.Data)
+	if p.Err != nil {
+		return
+	}
+	if !named {
+		pl.names[p.Origin] = name
+	}
+	if !ok {
+		pl.decoders[name] = d
+	}
+}
+
+// forget drops the decoder and the sender names of a driver that left, pl.mu is held.
+func (pl *Pipeline) forget(name string) {
+	delete(pl.decoders, name)
+	for from, n := range pl.names {
+		if n == name {
+			delete(pl.names, from)
+		}
+	}
+}
+
+// driver returns the driver named name sending from addr, created on its first packet.
+func (r *receiver) driver(t time.Time, name string, from netip.AddrPort) *Driver {
+	pl := r.pl
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if d, ok := pl.drivers[name]; ok {
+		d.Addr = from
+		return d
+	}
+	d := &Driver{Name: name, Addr: from, since: t, seq: sequencer{}}
+	d.status.Source = pl.Name
+	d.status.Driver = name
+	d.status.SetState(StateIdle)
+	d.timer = time.AfterFunc(pl.Timeout, func() {
+		r.transition(d, (*stateMachine).idle)
+		d.status.Deactivate()
+		p := d.status.Get()
+		p.received = time.Now()
+		pl.mu.Lock()
+		if pl.drivers[name] == d {
+			delete(pl.drivers, name)
+			pl.forget(name)
+		}
+		p.primary = pl.primary() == nil
+		prev := d.hooked
+		d.hooked = p
+		pl.mu.Unlock()
+		if p.primary {
+			pl.recorder.Close()
+		}
+		log.Printf("driver left: %s (%s)", name, pl.Name)
+		hooks.Fire(pl, &prev, &p)
+		stageRec.observe(&prev, &p)
+		r.ch <- p
+	})
+	pl.drivers[name] = d
+	log.Printf("driver joined: %s (%s)", name, pl.Name)
+	return d
+}
+
+func (r *receiver) Emit(p *Packet) {
+	pl := r.pl
+	if pl.Live {
+		relay.Send(p.Data)
+		pl.recorder.Record(p.Time, p.Origin, p.Format, p.Data)
+	}
+	if p.Err != nil {
+		log.Print(p.Err)
+		return
+	}
+	d := r.driver(p.Time, p.Driver, p.Origin)
+	res, lost := d.seq.check(p.Telemetry)
+	pl.mu.Lock()
+	d.stats.add(res, lost)
+	pl.stats.add(res, lost)
+	pl.mu.Unlock()
+	switch res {
+	case seqStale: // would move the overlay backwards
+		return
+	case seqDuplicate: // the sender is still alive, the game may be paused
+		d.timer.Reset(pl.Timeout)
+		if r.transition(d, func(m *stateMachine) bool { return m.freeze(p.Time) }) {
+			r.publish(p.Time, d)
+		}
+		return
+	}
+	if s, ok := p.Telemetry.(codemasters.Session); ok && s.Event() != codemasters.EventSessionUpdate {
+		switch s.Event() {
+		case codemasters.EventSessionPause:
+			d.timer.Stop()
+		case codemasters.EventSessionEnd:
+			d.timer.Stop()
+		case codemasters.EventSessionStart:
+			d.timer.Reset(pl.Timeout)
+			if pl.Live {
+				pl.recorder.Session(p.Time, s.SessionInfo())
+			}
+		default:
+			d.timer.Reset(pl.Timeout)
+		}
+		d.status.Session(s.Event(), s.SessionInfo())
+		r.transition(d, func(m *stateMachine) bool { return m.event(s.Event()) })
+		if s.Event() == codemasters.EventSessionEnd && pl.Live && !pl.active() {
+			pl.recorder.Close()
+		}
+		r.publish(p.Time, d)
+		return
+	}
+	// every packet is published, subscribers resample to their own rate
+	d.timer.Reset(pl.Timeout)
+	d.status.Activate()
+	d.status.Update(p.Telemetry, pl.Profiles)
+	f := d.status.Get().Frame
+	r.transition(d, func(m *stateMachine) bool { return m.frame(p.Time, &f) })
+	r.publish(p.Time, d)
+}
+
+// transition applies fn to the state machine of d and reports whether the state changed.
+func (r *receiver) transition(d *Driver, fn func(m *stateMachine) bool) bool {
+	r.pl.mu.Lock()
+	changed := fn(&d.state)
+	s := d.state.state
+	r.pl.mu.Unlock()
+	if changed {
+		d.status.SetState(s)
+		log.Printf("driver %s (%s): %s", d.Name, r.pl.Name, s)
+	}
+	return changed
+}
+
+func (r *receiver) publish(t time.Time, d *Driver) {
+	p := r.pl.publish(d)
+	p.received = t
+	r.pl.mu.Lock()
+	prev := d.hooked
+	d.hooked = p
+	r.pl.mu.Unlock()
+	hooks.Fire(r.pl, &prev, &p)
+	stageRec.observe(&prev, &p)
+	r.ch <- p
+}
+
+// Hold stops the inactivity timeouts, e.g. while a replay is paused.
+func (r *receiver) Hold() {
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	for _, d := range r.pl.drivers {
+		d.timer.Stop()
+	}
+}
+
+func (r *receiver) Rewind() {
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	for _, d := range r.pl.drivers {
+		d.seq = sequencer{}
+		d.state.rewind()
+	}
+}
+
+type SourceStatus struct {
+	Name    string
+	Source  string
+	Active  bool
+	Drivers int
+}
+
+// serveSources lists the sources.
+func serveSources(w http.ResponseWriter, r *http.Request) {
+	res := []SourceStatus{}
+	for _, pl := range pipelines {
+		pl.mu.Lock()
+		res = append(res, SourceStatus{Name: pl.Name, Source: pl.Source.String(), Active: pl.primary() != nil, Drivers: len(pl.drivers)})
+		pl.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+var testOrigin = netip.MustParseAddrPort("192.0.2.1:20777")
+
+func dirtPacket(t testing.TB, clock float32) []byte {
+	p := &codemasters.PacketDirtSeries{Time: clock, LapTime: clock, VehicleThrottle: 0.5, VehicleGear: 2, ExtraData: 3,
+		VehicleRightDirectionX: 1, VehicleForwardDirectionZ: 1}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testPacket decodes b as sent by testOrigin.
+func testPacket(t testing.TB, r *receiver, b []byte) *Packet {
+	p := &Packet{Time: time.Now(), Origin: testOrigin, Data: b}
+	r.Decode(p)
+	if p.Err != nil {
+		t.Fatal(p.Err)
+	}
+	return p
+}
+
+func testReceiver(t *testing.T) (*receiver, chan Params) {
+	pl, err := newPipeline("test", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl.Timeout = time.Hour
+	t.Cleanup(func() {
+		for _, d := range pl.drivers {
+			d.timer.Stop()
+		}
+	})
+	ch := make(chan Params, 16)
+	return &receiver{ch: ch, pl: pl}, ch
+}
+
+func TestReceiverEmit(t *testing.T) {
+	r, ch := testReceiver(t)
+	r.Emit(testPacket(t, r, dirtPacket(t, 1)))
+	p := <-ch
+	if p.Source != "test" || p.Driver != testOrigin.String() || !p.Active || !p.primary {
+		t.Errorf("first packet: %+v", p)
+	}
+	if p.State != StateMenu || p.Throttle != 0.5 || p.Gear != 2 {
+		t.Errorf("first packet: state %s throttle %v gear %d", p.State, p.Throttle, p.Gear)
+	}
+	r.Emit(testPacket(t, r, dirtPacket(t, 1.1)))
+	if p := <-ch; p.State != StateRunning {
+		t.Errorf("advancing clock: state %s, want %s", p.State, StateRunning)
+	}
+	r.Emit(testPacket(t, r, dirtPacket(t, 1.05))) // stale
+	r.Emit(testPacket(t, r, dirtPacket(t, 1.1)))  // duplicate, frozen for less than pauseDelay
+	if len(ch) != 0 {
+		t.Errorf("stale and duplicate packets published %d params", len(ch))
+	}
+	if st := r.pl.stats; st.Received != 2 || st.Stale != 1 || st.Duplicates != 1 {
+		t.Errorf("stats: %+v", st)
+	}
+}
+
+func TestReceiverEmitUnknown(t *testing.T) {
+	r, ch := testReceiver(t)
+	p := &Packet{Time: time.Now(), Origin: testOrigin, Data: []byte{1, 2, 3}}
+	r.Decode(p)
+	r.Emit(p)
+	if len(ch) != 0 || len(r.pl.drivers) != 0 {
+		t.Errorf("unknown packet published %d params, %d drivers", len(ch), len(r.pl.drivers))
+	}
+	if len(r.pl.decoders) != 0 || len(r.pl.names) != 0 {
+		t.Errorf("unknown packet kept %d decoders, %d names", len(r.pl.decoders), len(r.pl.names))
+	}
+}
+
+func TestReceiverDecode(t *testing.T) {
+	r, _ := testReceiver(t)
+	b := dirtPacket(t, 1)
+	p := testPacket(t, r, b)
+	if p.Driver != testOrigin.String() {
+		t.Errorf("driver %q, want %q", p.Driver, testOrigin)
+	}
+	if n := testing.AllocsPerRun(100, func() { r.Decode(p) }); n != 0 {
+		t.Errorf("Decode: %v allocs per packet", n)
+	}
+}
+
+func TestReceiverTimeout(t *testing.T) {
+	r, ch := testReceiver(t)
+	r.pl.Timeout = 10 * time.Millisecond
+	r.Emit(testPacket(t, r, dirtPacket(t, 1)))
+	if p := <-ch; !p.Active {
+		t.Fatalf("first packet: inactive")
+	}
+	select {
+	case p := <-ch:
+		if p.Active || p.State != StateIdle {
+			t.Errorf("timeout: active %v state %s", p.Active, p.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no timeout")
+	}
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	if len(r.pl.drivers) != 0 || len(r.pl.decoders) != 0 || len(r.pl.names) != 0 {
+		t.Errorf("driver left: %d drivers, %d decoders, %d names", len(r.pl.drivers), len(r.pl.decoders), len(r.pl.names))
+	}
+}
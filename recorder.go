@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/capture"
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// version is overridden by -ldflags "-X main.version=..."
+var version = ""
+
+func softwareVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Path + "@" + info.Main.Version
+	}
+	return "unknown"
+}
+
+// Recorder writes every raw datagram into capture files in dir.
+// A file is opened by the first packet and closed by Close,
+// so each active period of the game becomes its own file.
+type Recorder struct {
+	dir     string
+	mu      sync.Mutex
+	stopped bool // by Stop until Start
+	f       *os.File
+	w       *capture.Writer
+	header  capture.Header
+}
+
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir}
+}
+
+// Record appends a datagram, errors are logged and close the file.
+// The sender is only formatted when written, so a stopped recorder doesn't allocate.
+func (r *Recorder) Record(t time.Time, from netip.AddrPort, format codemasters.Format, b []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	if r.w == nil {
+		if format == codemasters.FormatUnknown {
+			return
+		}
+		if err := r.open(t, format); err != nil {
+			log.Print("recorder:", err)
+			return
+		}
+	}
+	if err := r.w.WritePacket(capture.Packet{Time: t, Addr: from.String(), Format: string(format), Data: b}); err != nil {
+		log.Print("recorder:", err)
+		r.close()
+	}
+}
+
+// Session records the car and stage of the current session.
+func (r *Recorder) Session(t time.Time, info codemasters.SessionInfo) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.header.Car = fmt.Sprintf("vehicle:%d", info.VehicleId)
+	r.header.Stage = fmt.Sprintf("location:%d/route:%d", info.LocationId, info.RouteId)
+	if r.w != nil {
+		if err := r.w.WriteMeta(t, r.header); err != nil {
+			log.Print("recorder:", err)
+			r.close()
+		}
+	}
+}
+
+func (r *Recorder) open(t time.Time, format codemasters.Format) error {
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := create(filepath.Join(r.dir, t.Format("20060102-150405")), ".cmtc")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	r.header.Software = softwareVersion()
+	r.header.Created = t
+	r.header.Game = string(format)
+	w, err := capture.NewWriter(f, r.header)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	log.Print("recording:", name)
+	r.f, r.w = f, w
+	return nil
+}
+
+// create creates a new file base+ext, or base-1+ext, base-2+ext... if it exists,
+// so a capture is never appended to another one.
+func create(base, ext string) (*os.File, error) {
+	name := base + ext
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if !errors.Is(err, fs.ErrExist) || i > 100 {
+			return f, err
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// Close flushes and closes the current file, the next packet opens a new one.
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.close()
+}
+
+// Start resumes recording after Stop.
+func (r *Recorder) Start() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		log.Print("recorder started:", r.dir)
+	}
+	r.stopped = false
+}
+
+// Stop closes the current file and drops packets until Start.
+func (r *Recorder) Stop() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.close()
+	if !r.stopped {
+		log.Print("recorder stopped:", r.dir)
+	}
+	r.stopped = true
+}
+
+func (r *Recorder) close() {
+	if r.w == nil {
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		log.Print("recorder:", err)
+	}
+	if err := r.f.Close(); err != nil {
+		log.Print("recorder:", err)
+	}
+	log.Print("recording closed:", r.f.Name())
+	r.f, r.w = nil, nil
+	r.header = capture.Header{}
+}
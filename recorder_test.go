@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/capture"
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+func TestRecorderAllocs(t *testing.T) {
+	p := &Packet{Time: time.Now(), Origin: testOrigin, Format: codemasters.FormatDirtSeries, Data: dirtPacket(t, 1)}
+	var r *Recorder // RECORD_DIR not set
+	if n := testing.AllocsPerRun(100, func() { r.Record(p.Time, p.Origin, p.Format, p.Data) }); n != 0 {
+		t.Errorf("nil recorder: %v allocs per packet", n)
+	}
+	r = NewRecorder(t.TempDir())
+	r.Stop()
+	if n := testing.AllocsPerRun(100, func() { r.Record(p.Time, p.Origin, p.Format, p.Data) }); n != 0 {
+		t.Errorf("stopped recorder: %v allocs per packet", n)
+	}
+}
+
+// Recordings started in the same second get their own files.
+func TestRecorderSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		r := NewRecorder(dir)
+		r.Record(now, testOrigin, codemasters.FormatDirtSeries, dirtPacket(t, float32(i)))
+		r.Close()
+	}
+	base := now.Format("20060102-150405")
+	for _, name := range []string{base + ".cmtc", base + "-1.cmtc", base + "-2.cmtc"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cr, err := capture.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		n := 0
+		for ; ; n++ {
+			if _, err := cr.Next(); err != nil {
+				break
+			}
+		}
+		f.Close()
+		if n != 1 {
+			t.Errorf("%s: %d packets, want 1", name, n)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+const relayQueue = 256
+
+// Relay forwards an exact copy of every raw datagram to other telemetry tools,
+// e.g. motion rigs and dashboards, as the game sends to a single address.
+// A slow or failing target drops its datagrams without delaying the others.
+type Relay struct {
+	targets []*relayTarget
+}
+
+type relayTarget struct {
+	addr    string
+	conn    *net.UDPConn
+	enabled atomic.Bool
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	queue   chan []byte
+	free    chan []byte
+}
+
+type RelayStatus struct {
+	Target  string
+	Enabled bool
+	Sent    uint64
+	Dropped uint64 // queue full or send error
+}
+
+// NewRelay connects targets ("host:port"), all enabled.
+func NewRelay(targets []string) (*Relay, error) {
+	r := &Relay{}
+	for _, addr := range targets {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialUDP("udp", nil, udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		t := &relayTarget{
+			addr:  addr,
+			conn:  conn,
+			queue: make(chan []byte, relayQueue),
+			free:  make(chan []byte, relayQueue),
+		}
+		for i := 0; i < relayQueue; i++ {
+			t.free <- make([]byte, 0, 4096)
+		}
+		t.enabled.Store(true)
+		r.targets = append(r.targets, t)
+	}
+	return r, nil
+}
+
+// Run sends queued datagrams until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for _, t := range r.targets {
+		log.Println("relay to:", t.addr)
+		go t.run(ctx)
+	}
+	<-ctx.Done()
+	for _, t := range r.targets {
+		t.conn.Close()
+	}
+}
+
+// Send queues a copy of b to every enabled target, it never blocks.
+func (r *Relay) Send(b []byte) {
+	if r == nil {
+		return
+	}
+	for _, t := range r.targets {
+		if !t.enabled.Load() {
+			continue
+		}
+		select {
+		case buf := <-t.free:
+			t.queue <- append(buf[:0], b...)
+		default:
+			t.dropped.Add(1)
+		}
+	}
+}
+
+func (t *relayTarget) run(ctx context.Context) {
+	for {
+		var b []byte
+		select {
+		case <-ctx.Done():
+			return
+		case b = <-t.queue:
+		}
+		if _, err := t.conn.Write(b); err != nil {
+			if t.dropped.Add(1) == 1 {
+				log.Printf("relay %s: %v", t.addr, err)
+			}
+		} else {
+			t.sent.Add(1)
+		}
+		t.free <- b
+	}
+}
+
+// Enable enables or disables target, an empty target matches all.
+// It reports whether target is known.
+func (r *Relay) Enable(target string, enabled bool) bool {
+	if r == nil {
+		return false
+	}
+	found := false
+	for _, t := range r.targets {
+		if target == "" || t.addr == target {
+			t.enabled.Store(enabled)
+			found = true
+		}
+	}
+	return found
+}
+
+func (r *Relay) Status() []RelayStatus {
+	res := []RelayStatus{}
+	if r == nil {
+		return res
+	}
+	for _, t := range r.targets {
+		res = append(res, RelayStatus{
+			Target:  t.addr,
+			Enabled: t.enabled.Load(),
+			Sent:    t.sent.Load(),
+			Dropped: t.dropped.Load(),
+		})
+	}
+	return res
+}
+
+// ServeHTTP controls the relay:
+//
+//	/relay                          status of all targets
+//	/relay/enable?target=host:port  enable a target, all without target (POST)
+//	/relay/disable?target=host:port disable a target, all without target (POST)
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	target := req.URL.Query().Get("target")
+	switch req.URL.Path {
+	case "/relay", "/relay/":
+	case "/relay/enable", "/relay/disable":
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !r.Enable(target, req.URL.Path == "/relay/enable") {
+			http.Error(w, "unknown target: "+target, http.StatusNotFound)
+			return
+		}
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Status())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testRelay returns a relay to n local listeners.
+func testRelay(t *testing.T, n int) (*Relay, []*net.UDPConn) {
+	var targets []string
+	var conns []*net.UDPConn
+	for i := 0; i < n; i++ {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		conns = append(conns, c)
+		targets = append(targets, c.LocalAddr().String())
+	}
+	r, err := NewRelay(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, conns
+}
+
+func TestRelayFanOut(t *testing.T) {
+	r, conns := testRelay(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+	r.Send([]byte("datagram"))
+	buf := make([]byte, 64)
+	for i, c := range conns {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := c.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], []byte("datagram")) {
+			t.Errorf("target %d: %q, %v", i, buf[:n], err)
+		}
+	}
+}
+
+// A target with a full queue drops its datagrams, the others still send them.
+func TestRelayDrop(t *testing.T) {
+	r, _ := testRelay(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.targets[0].run(ctx) // the second target is stuck
+	for i := 0; i < relayQueue; i++ {
+		r.Send([]byte{byte(i)})
+	}
+	for deadline := time.Now().Add(5 * time.Second); r.targets[0].sent.Load() < relayQueue; {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d of %d", r.targets[0].sent.Load(), relayQueue)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		r.Send([]byte{byte(i)})
+	}
+	st := r.Status()
+	if st[0].Dropped != 0 || st[1].Dropped != 10 {
+		t.Errorf("dropped %d and %d, want 0 and 10", st[0].Dropped, st[1].Dropped)
+	}
+}
+
+func TestRelayEnable(t *testing.T) {
+	r, conns := testRelay(t, 2)
+	target := conns[1].LocalAddr().String()
+	serve := func(method, url string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		return w.Code
+	}
+	if code := serve(http.MethodGet, "/relay/disable?target="+target); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET disable: status %d", code)
+	}
+	if st := r.Status(); !st[1].Enabled {
+		t.Error("disabled by GET")
+	}
+	if code := serve(http.MethodPost, "/relay/disable?target="+target); code != http.StatusOK {
+		t.Errorf("POST disable: status %d", code)
+	}
+	if code := serve(http.MethodPost, "/relay/disable?target=192.0.2.1:1"); code != http.StatusNotFound {
+		t.Errorf("unknown target: status %d", code)
+	}
+	r.Send([]byte("datagram"))
+	if st := r.Status(); !st[0].Enabled || st[1].Enabled || len(r.targets[0].queue) != 1 || len(r.targets[1].queue) != 0 {
+		t.Errorf("after disable: %+v", st)
+	}
+	if code := serve(http.MethodPost, "/relay/enable"); code != http.StatusOK {
+		t.Errorf("POST enable all: status %d", code)
+	}
+	if st := r.Status(); !st[0].Enabled || !st[1].Enabled {
+		t.Errorf("after enable: %+v", st)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/capture"
+)
+
+// Replayer feeds the packets of a capture file into a receiver.
+type Replayer struct {
+	name    string
+	header  capture.Header
+	packets []capture.Packet
+	loop    bool
+	wake    chan struct{}
+
+	mu      sync.Mutex
+	pos     int
+	speed   float64
+	paused  bool
+	steps   int
+	rewound bool      // pos moved, the packet order restarts
+	base    time.Time // wall clock time of packets[basePos]
+	basePos int
+}
+
+type ReplayStatus struct {
+	File     string
+	Header   capture.Header
+	Index    int
+	Count    int
+	Position float64 // seconds from the first packet
+	Duration float64 // seconds
+	Speed    float64
+	Paused   bool
+}
+
+// LoadReplay reads a whole capture file into memory.
+// pcap and pcapng files are accepted too, filtered by PCAP_PORT and PCAP_HOST.
+func LoadReplay(name string, speed float64, loop bool) (*Replayer, error) {
+	if !validSpeed(speed) {
+		return nil, fmt.Errorf("invalid speed: %v", speed)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rp := &Replayer{name: name, loop: loop, speed: speed, wake: make(chan struct{}, 1)}
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(len(capture.Magic)); string(head) == capture.Magic {
+		err = rp.readCapture(br)
+	} else {
+		rp.header.Software = softwareVersion()
+		err = readPcap(br, pcapFilter{Port: config.PcapPort, Host: config.PcapHost}, func(p capture.Packet) error {
+			if rp.header.Game == "" {
+				rp.header.Game = p.Format
+			}
+			p.Data = append([]byte(nil), p.Data...)
+			rp.packets = append(rp.packets, p)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(rp.packets) == 0 {
+		return nil, fmt.Errorf("%s: no packets", name)
+	}
+	if rp.header.Created.IsZero() {
+		rp.header.Created = rp.packets[0].Time
+	}
+	return rp, nil
+}
+
+func (rp *Replayer) readCapture(r io.Reader) error {
+	cr, err := capture.NewReader(r)
+	if err != nil {
+		return err
+	}
+	rp.header = cr.Header()
+	for {
+		p, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.Data = append([]byte(nil), p.Data...)
+		rp.packets = append(rp.packets, p)
+	}
+}
+
+func (rp *Replayer) notify() {
+	select {
+	case rp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// rebase restarts the real-time clock at the current position.
+func (rp *Replayer) rebase() {
+	rp.base = time.Now()
+	rp.basePos = rp.pos
+}
+
+func (rp *Replayer) String() string {
+	return "replay " + rp.name
+}
+
+// Run replays packets into sink until ctx is done.
+func (rp *Replayer) Run(ctx context.Context, sink Sink) error {
+	log.Printf("replay start: %s (%d packets)", rp.name, len(rp.packets))
+	defer log.Println("replay stopped:", rp.name)
+	rp.mu.Lock()
+	rp.rebase()
+	rp.mu.Unlock()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		rp.mu.Lock()
+		if rp.pos >= len(rp.packets) {
+			if !rp.loop {
+				rp.paused = true
+			}
+			rp.pos = 0
+			rp.rewound = true
+			rp.rebase()
+		}
+		if rp.rewound {
+			rp.rewound = false
+			sink.Rewind()
+		}
+		idle := rp.paused && rp.steps == 0
+		var wait time.Duration
+		switch {
+		case rp.paused && rp.steps > 0:
+			rp.steps--
+		case !rp.paused:
+			p, b := rp.packets[rp.pos], rp.packets[rp.basePos]
+			wait = time.Until(rp.base.Add(time.Duration(float64(p.Time.Sub(b.Time)) / rp.speed)))
+		}
+		rp.mu.Unlock()
+		if idle {
+			sink.Hold()
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-rp.wake:
+			}
+			continue
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-rp.wake:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				continue
+			case <-timer.C:
+			}
+		}
+		rp.mu.Lock()
+		if rp.pos >= len(rp.packets) {
+			rp.mu.Unlock()
+			continue
+		}
+		p := rp.packets[rp.pos]
+		rp.pos++
+		rp.mu.Unlock()
+		from, _ := netip.ParseAddrPort(p.Addr)
+		pkt := Packet{Time: time.Now(), Origin: from, Data: p.Data}
+		sink.Decode(&pkt)
+		sink.Emit(&pkt)
+	}
+}
+
+func (rp *Replayer) Pause() {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.paused = true
+	rp.notify()
+}
+
+func (rp *Replayer) Resume() {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.paused = false
+	rp.steps = 0
+	rp.rebase()
+	rp.notify()
+}
+
+// Step emits the next packet while paused.
+func (rp *Replayer) Step() {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.paused = true
+	rp.steps++
+	rp.notify()
+}
+
+// Seek moves to the first packet at or after d from the first packet.
+func (rp *Replayer) Seek(d time.Duration) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	t := rp.packets[0].Time.Add(d)
+	rp.pos = sort.Search(len(rp.packets), func(i int) bool {
+		return !rp.packets[i].Time.Before(t)
+	})
+	rp.rewound = true
+	rp.rebase()
+	rp.notify()
+}
+
+// validSeek rejects seek positions in seconds which are NaN or out of the time.Duration range.
+func validSeek(t float64) bool {
+	return math.Abs(t) < float64(math.MaxInt64/time.Second)
+}
+
+// validSpeed rejects speeds which would stall the replay or never wait: NaN, infinite, <= 0.
+func validSpeed(speed float64) bool {
+	return speed > 0 && !math.IsInf(speed, 0)
+}
+
+func (rp *Replayer) SetSpeed(speed float64) error {
+	if !validSpeed(speed) {
+		return fmt.Errorf("invalid speed: %v", speed)
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.speed = speed
+	rp.rebase()
+	rp.notify()
+	return nil
+}
+
+func (rp *Replayer) Status() ReplayStatus {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	first, last := rp.packets[0].Time, rp.packets[len(rp.packets)-1].Time
+	pos := first
+	if rp.pos < len(rp.packets) {
+		pos = rp.packets[rp.pos].Time
+	}
+	return ReplayStatus{
+		File:     rp.name,
+		Header:   rp.header,
+		Index:    rp.pos,
+		Count:    len(rp.packets),
+		Position: pos.Sub(first).Seconds(),
+		Duration: last.Sub(first).Seconds(),
+		Speed:    rp.speed,
+		Paused:   rp.paused,
+	}
+}
+
+// ServeHTTP controls the replay:
+//
+//	/replay             status
+//	/replay/pause       pause
+//	/replay/resume      resume
+//	/replay/step        emit the next packet and pause
+//	/replay/seek?t=12.5 seek to seconds from the start
+//	/replay/speed?x=2   playback speed
+func (rp *Replayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/replay", "/replay/":
+	case "/replay/pause":
+		rp.Pause()
+	case "/replay/resume":
+		rp.Resume()
+	case "/replay/step":
+		rp.Step()
+	case "/replay/seek":
+		t, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+		if err == nil && !validSeek(t) {
+			err = fmt.Errorf("invalid time: %v", t)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rp.Seek(time.Duration(t * float64(time.Second)))
+	case "/replay/speed":
+		x, err := strconv.ParseFloat(r.URL.Query().Get("x"), 64)
+		if err == nil {
+			err = rp.SetSpeed(x)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rp.Status())
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/capture"
+)
+
+func TestReplaySpeed(t *testing.T) {
+	rp := &Replayer{speed: 1, wake: make(chan struct{}, 1)}
+	for _, x := range []float64{0, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := rp.SetSpeed(x); err == nil {
+			t.Errorf("speed %v: no error", x)
+		}
+	}
+	if err := rp.SetSpeed(2); err != nil || rp.speed != 2 {
+		t.Errorf("speed 2: %v, speed %v", err, rp.speed)
+	}
+}
+
+func TestLoadReplaySpeed(t *testing.T) {
+	for _, x := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, err := LoadReplay("missing.cmtc", x, false); err == nil || err.Error() != fmt.Sprintf("invalid speed: %v", x) {
+			t.Errorf("speed %v: %v", x, err)
+		}
+	}
+}
+
+func TestReplaySeek(t *testing.T) {
+	rp := &Replayer{speed: 1, wake: make(chan struct{}, 1), packets: []capture.Packet{{Time: time.Unix(1, 0)}, {Time: time.Unix(2, 0)}}}
+	for _, q := range []string{"NaN", "Inf", "-Inf", "1e300", "x"} {
+		w := httptest.NewRecorder()
+		rp.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/replay/seek?t="+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("seek %s: status %d", q, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/replay/seek?t=0.5", nil))
+	if w.Code != http.StatusOK || rp.pos != 1 {
+		t.Errorf("seek 0.5: status %d position %d", w.Code, rp.pos)
+	}
+}
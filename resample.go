@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	minRate = 0.1 // messages per second
+	maxRate = 1000
+)
+
+// checkRate accepts 0 (every packet) or minRate to maxRate messages per second.
+func checkRate(rate float64) error {
+	if rate == 0 || minRate <= rate && rate <= maxRate {
+		return nil
+	}
+	return fmt.Errorf("invalid rate: %v, want 0 or %v to %v", rate, minRate, maxRate)
+}
+
+// ResampleMode selects how a subscriber's publish rate is produced from the packet rate.
+type ResampleMode string
+
+const (
+	ResampleLatest      ResampleMode = "latest"      // latest packet since the last publish
+	ResampleAverage     ResampleMode = "average"     // average of the packets since the last publish
+	ResampleInterpolate ResampleMode = "interpolate" // interpolated between the last two packets, one packet late
+)
+
+func (m *ResampleMode) UnmarshalText(text []byte) error {
+	switch v := ResampleMode(text); v {
+	case ResampleLatest, ResampleAverage, ResampleInterpolate:
+		*m = v
+		return nil
+	}
+	return fmt.Errorf("unknown resample mode: %q", text)
+}
+
+const numContinuous = 22
+
+// continuous returns the fields that are averaged or interpolated,
+// the others are taken from the latest packet.
+func continuous(p *Params) [numContinuous]*float32 {
+	f := &p.Frame
+	return [numContinuous]*float32{
+		&p.Steer, &p.Clutch, &p.Brake, &p.Throttle, &p.Speed,
+		&f.Steering, &f.Throttle, &f.Brake, &f.Clutch, &f.Handbrake, &f.RPM, &f.Speed,
+		&f.Position.X, &f.Position.Y, &f.Position.Z,
+		&f.Velocity.X, &f.Velocity.Y, &f.Velocity.Z,
+		&f.GForceLateral, &f.GForceLongitudinal,
+		&f.StageDistance, &f.StageProgress,
+	}
+}
+
+// resampler turns the Params of every packet into Params at a fixed rate.
+type resampler struct {
+	mode   ResampleMode
+	latest Params
+	prev   Params // interpolate: the packet before latest
+	fresh  bool   // latest not published yet
+	sum    [numContinuous]float64
+	n      int
+}
+
+// changed reports whether p changes the state of the overlay,
+// such changes are published at once instead of being resampled.
+func (r *resampler) changed(p *Params) bool {
+	l := &r.latest
+	return p.Driver != l.Driver || p.Active != l.Active || p.Paused != l.Paused || p.State != l.State ||
+		p.Session != l.Session || p.Frame.Format != l.Frame.Format
+}
+
+func (r *resampler) add(p Params) {
+	if r.mode == ResampleAverage {
+		for i, v := range continuous(&p) {
+			r.sum[i] += float64(*v)
+		}
+		r.n++
+	}
+	r.prev, r.latest = r.latest, p
+	r.fresh = true
+}
+
+// next returns the Params to publish at now, false if nothing changed since the last call.
+func (r *resampler) next(now time.Time) (Params, bool) {
+	switch r.mode {
+	case ResampleAverage:
+		if r.n == 0 {
+			return Params{}, false
+		}
+		p := r.latest
+		for i, v := range continuous(&p) {
+			*v = float32(r.sum[i] / float64(r.n))
+			r.sum[i] = 0
+		}
+		r.n = 0
+		r.fresh = false
+		return p, true
+	case ResampleInterpolate:
+		span := r.latest.received.Sub(r.prev.received)
+		if r.prev.received.IsZero() || span <= 0 || r.prev.Driver != r.latest.Driver {
+			break
+		}
+		// render one packet interval in the past, so both ends are known
+		alpha := float32(now.Sub(r.prev.received)-span) / float32(span)
+		if alpha >= 1 {
+			break
+		}
+		if alpha < 0 {
+			alpha = 0
+		}
+		p := r.latest
+		a := continuous(&r.prev)
+		for i, v := range continuous(&p) {
+			*v = *a[i] + (*v-*a[i])*alpha
+		}
+		r.fresh = true // the latest packet is still to be reached
+		return p, true
+	}
+	if !r.fresh {
+		return Params{}, false
+	}
+	r.fresh = false
+	return r.latest, true
+}
+
+// last returns the packets not published yet, false if none.
+// It is published before a state change.
+func (r *resampler) last() (Params, bool) {
+	if r.mode == ResampleAverage {
+		return r.next(time.Time{})
+	}
+	if !r.fresh {
+		return Params{}, false
+	}
+	r.fresh = false
+	return r.latest, true
+}
+
+// flush returns p as the latest packet, averaged with nothing.
+func (r *resampler) flush(p Params) Params {
+	r.sum = [numContinuous]float64{}
+	r.n = 0
+	r.prev, r.latest = Params{}, p
+	r.fresh = false
+	return p
+}
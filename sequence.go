@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Backward or forward jumps beyond these restart a sequence, e.g. a new stage or game session.
+const (
+	seqResetCount = 1000 // packets
+	seqResetTime  = 1.0  // seconds
+)
+
+type seqResult int
+
+const (
+	seqNone      seqResult = iota // the packet carries no sequence
+	seqNext                       // in order
+	seqGap                        // in order after lost packets
+	seqDuplicate                  // same as the last packet, dropped
+	seqStale                      // older than the last packet, dropped
+	seqReset                      // the sequence restarted
+)
+
+type seqKey struct {
+	format codemasters.Format
+	stream int // F1 series packet id
+}
+
+type seqState struct {
+	epoch uint64 // F1 series session uid
+	last  float64
+}
+
+// sequencer orders the packets of a driver by EA Sports WRC packet_uid,
+// DiRT time or F1 series frame identifier (per packet id).
+// Lost packets are only counted for packet_uid, the others skip values by design.
+type sequencer map[seqKey]*seqState
+
+// sequenceOf returns the position of t in its sequence, counter reports a packet counter.
+func sequenceOf(t codemasters.Telemetry) (key seqKey, epoch uint64, n float64, counter, ok bool) {
+	switch p := t.(type) {
+	case *codemasters.PacketEASportsWRC:
+		return seqKey{format: codemasters.FormatEASportsWRC}, 0, float64(p.PacketUid), true, true
+	case *codemasters.PacketEASportsWRCSession:
+		return seqKey{format: codemasters.FormatEASportsWRC}, 0, float64(p.PacketUid), true, true
+	case *codemasters.PacketEASportsWRCCustom:
+		v, ok := p.Values["packet_uid"]
+		return seqKey{format: codemasters.FormatEASportsWRC}, 0, v, true, ok
+	case *codemasters.PacketDirtSeries:
+		return seqKey{format: codemasters.FormatDirtSeries}, 0, float64(p.Time), false, true
+	case *codemasters.PacketF1Series:
+		h := &p.Header
+		return seqKey{codemasters.FormatF1Series, int(h.PacketId)}, h.SessionUid, float64(h.OverallFrameIdentifier), true, true
+	}
+	return seqKey{}, 0, 0, false, false
+}
+
+// check places t in its sequence, lost is the number of packets missing before t.
+func (s sequencer) check(t codemasters.Telemetry) (res seqResult, lost uint64) {
+	key, epoch, n, counter, ok := sequenceOf(t)
+	if !ok {
+		return seqNone, 0
+	}
+	st, ok := s[key]
+	if !ok {
+		s[key] = &seqState{epoch: epoch, last: n}
+		return seqNext, 0
+	}
+	limit := seqResetTime
+	if counter {
+		limit = seqResetCount
+	}
+	d := n - st.last
+	switch {
+	case st.epoch != epoch || d < -limit || (counter && d > limit):
+		res = seqReset
+	case d == 0:
+		return seqDuplicate, 0
+	case d < 0:
+		return seqStale, 0
+	case counter && key.format != codemasters.FormatF1Series && d > 1:
+		res, lost = seqGap, uint64(d-1)
+	default:
+		res = seqNext
+	}
+	st.epoch, st.last = epoch, n
+	return res, lost
+}
+
+// SequenceStats counts the packets of a driver or a source by their sequence.
+type SequenceStats struct {
+	Received   uint64  // packets in order
+	Lost       uint64  // missing from packet counters
+	Duplicates uint64  // dropped
+	Stale      uint64  // out of order, dropped
+	Resets     uint64  // sequence restarts
+	Loss       float64 // Lost / (Received + Lost)
+}
+
+func (st *SequenceStats) add(res seqResult, lost uint64) {
+	switch res {
+	case seqNext, seqGap, seqReset:
+		st.Received++
+		st.Lost += lost
+		if res == seqReset {
+			st.Resets++
+		}
+	case seqDuplicate:
+		st.Duplicates++
+	case seqStale:
+		st.Stale++
+	}
+	if n := st.Received + st.Lost; n > 0 {
+		st.Loss = float64(st.Lost) / float64(n)
+	}
+}
+
+type DriverStats struct {
+	Name     string
+	Sequence SequenceStats
+}
+
+type SourceStats struct {
+	Source   string
+	Sequence SequenceStats // all drivers since the start
+	Drivers  []DriverStats // current drivers
+}
+
+// serveStats reports packet loss of ?source=name, of all sources by default.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	res := []SourceStats{}
+	for _, pl := range pipelines {
+		if name := r.URL.Query().Get("source"); name != "" && name != pl.Name {
+			continue
+		}
+		pl.mu.Lock()
+		st := SourceStats{Source: pl.Name, Sequence: pl.stats, Drivers: []DriverStats{}}
+		for _, d := range pl.drivers {
+			st.Drivers = append(st.Drivers, DriverStats{Name: d.Name, Sequence: d.stats})
+		}
+		pl.mu.Unlock()
+		res = append(res, st)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
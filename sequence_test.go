@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+type seqStep struct {
+	t    codemasters.Telemetry
+	res  seqResult
+	lost uint64
+}
+
+func checkSequence(t *testing.T, name string, steps []seqStep) SequenceStats {
+	t.Helper()
+	s := sequencer{}
+	var st SequenceStats
+	for i, c := range steps {
+		res, lost := s.check(c.t)
+		if res != c.res || lost != c.lost {
+			t.Errorf("%s %d: result %d lost %d, want %d lost %d", name, i, res, lost, c.res, c.lost)
+		}
+		st.add(res, lost)
+	}
+	return st
+}
+
+func TestSequencePacketUid(t *testing.T) {
+	uid := func(n uint64) codemasters.Telemetry { return &codemasters.PacketEASportsWRC{PacketUid: n} }
+	st := checkSequence(t, "packet_uid", []seqStep{
+		{uid(1), seqNext, 0},
+		{uid(2), seqNext, 0},
+		{uid(5), seqGap, 2},
+		{uid(5), seqDuplicate, 0},
+		{uid(4), seqStale, 0},
+		{uid(6), seqNext, 0},
+		{uid(6 + seqResetCount + 1), seqReset, 0}, // new session
+		{uid(1), seqReset, 0},                     // game restarted
+		{uid(2), seqNext, 0},
+	})
+	want := SequenceStats{Received: 7, Lost: 2, Duplicates: 1, Stale: 1, Resets: 2, Loss: 2.0 / 9}
+	if st != want {
+		t.Errorf("stats %+v, want %+v", st, want)
+	}
+}
+
+func TestSequenceDirtTime(t *testing.T) {
+	at := func(s float32) codemasters.Telemetry { return &codemasters.PacketDirtSeries{Time: s} }
+	st := checkSequence(t, "dirt", []seqStep{
+		{at(10), seqNext, 0},
+		{at(10.5), seqNext, 0},
+		{at(10.5), seqDuplicate, 0},
+		{at(10.2), seqStale, 0}, // reordered
+		{at(2), seqReset, 0},    // restart, more than seqResetTime back
+		{at(60), seqNext, 0},    // time skips forward without loss
+		{at(59.5), seqStale, 0},
+	})
+	if st.Lost != 0 || st.Resets != 1 || st.Stale != 2 || st.Received != 4 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestSequenceF1(t *testing.T) {
+	f1 := func(session uint64, id uint8, frame uint32) codemasters.Telemetry {
+		return &codemasters.PacketF1Series{Header: codemasters.PacketF1Header{
+			SessionUid: session, PacketId: id, OverallFrameIdentifier: frame,
+		}}
+	}
+	checkSequence(t, "f1", []seqStep{
+		{f1(1, codemasters.F1PacketMotion, 10), seqNext, 0},
+		{f1(1, codemasters.F1PacketCarTelemetry, 10), seqNext, 0}, // own stream
+		{f1(1, codemasters.F1PacketMotion, 13), seqNext, 0},       // frames skip by design
+		{f1(1, codemasters.F1PacketMotion, 12), seqStale, 0},
+		{f1(1, codemasters.F1PacketCarTelemetry, 10), seqDuplicate, 0},
+		{f1(2, codemasters.F1PacketMotion, 1), seqReset, 0}, // new session
+	})
+}
+
+func TestServeStats(t *testing.T) {
+	r, _ := testReceiver(t)
+	for _, clock := range []float32{1, 1.1, 1.1, 1.05} {
+		r.Emit(testPacket(t, r, dirtPacket(t, clock)))
+	}
+	saved := pipelines
+	pipelines = []*Pipeline{r.pl}
+	t.Cleanup(func() { pipelines = saved })
+
+	w := httptest.NewRecorder()
+	serveStats(w, httptest.NewRequest("GET", "/stats?source=test", nil))
+	var res []SourceStats
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	want := SequenceStats{Received: 2, Duplicates: 1, Stale: 1}
+	if len(res) != 1 || res[0].Source != "test" || res[0].Sequence != want ||
+		len(res[0].Drivers) != 1 || res[0].Drivers[0].Sequence != want {
+		t.Errorf("stats: %+v", res)
+	}
+
+	w = httptest.NewRecorder()
+	serveStats(w, httptest.NewRequest("GET", "/stats?source=other", nil))
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("other source: %s", body)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// Source produces decoded telemetry, e.g. from UDP, a capture replay or a generator.
+type Source interface {
+	// Run emits packets to sink until ctx is done or the source fails.
+	Run(ctx context.Context, sink Sink) error
+	String() string // e.g. "udp 127.0.0.1:20777"
+}
+
+// Sink receives the packets of a Source, it is implemented by the pipeline.
+type Sink interface {
+	// Decode names the driver of p and decodes it with the decoder of the driver,
+	// as the state of F1 series spans several packets.
+	Decode(p *Packet)
+	// Emit processes p decoded by Decode, p is only valid during the call.
+	Emit(p *Packet)
+	// Hold stops inactivity timeouts while the source is paused on purpose.
+	Hold()
+	// Rewind forgets the packet order, e.g. after a replay seeks backwards.
+	Rewind()
+}
+
+// Packet is a decoded datagram with its metadata.
+type Packet struct {
+	Time      time.Time             // receive time
+	Origin    netip.AddrPort        // sender
+	Driver    string                // name of the sender, see Aliases
+	Format    codemasters.Format    // FormatUnknown if not detected
+	Data      []byte                // raw datagram
+	Telemetry codemasters.Telemetry // nil if Err is set
+	Err       error
+}
+
+// UDPSource receives datagrams from the game.
+type UDPSource struct {
+	Listen string
+}
+
+func (s *UDPSource) String() string {
+	return "udp " + s.Listen
+}
+
+func (s *UDPSource) Run(ctx context.Context, sink Sink) error {
+	host, port, err := net.SplitHostPort(s.Listen)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(host, port)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) // unblocks the read
+	defer stop()
+	log.Println("listen udp:", addr)
+	defer log.Println("udp closed:", addr)
+	b := make([]byte, 4096)
+	var p Packet
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(b) // no net.Addr allocation per packet
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		p = Packet{Time: time.Now(), Origin: from, Data: b[:n]}
+		sink.Decode(&p)
+		sink.Emit(&p)
+	}
+}
+
+// SourceConfig is a SOURCES entry.
+type SourceConfig struct {
+	Name   string
+	Listen string
+}
+
+var sourceName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Sources is parsed from "name=host:port,...".
+type Sources []SourceConfig
+
+func (ss *Sources) UnmarshalText(text []byte) error {
+	res := Sources{}
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(string(text), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(entry, "=")
+		if !ok || !sourceName.MatchString(name) || addr == "" {
+			return fmt.Errorf("invalid source: %q", entry)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate source: %q", name)
+		}
+		seen[name] = true
+		res = append(res, SourceConfig{Name: name, Listen: addr})
+	}
+	*ss = res
+	return nil
+}
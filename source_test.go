@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// testSink collects the packets of a source.
+type testSink struct {
+	decoder *codemasters.Decoder
+	packets chan Packet
+}
+
+func (s *testSink) Decode(p *Packet) {
+	p.Telemetry, p.Format, p.Err = s.decoder.Decode(p.Data)
+}
+
+func (s *testSink) Emit(p *Packet) {
+	c := *p
+	c.Data = append([]byte(nil), p.Data...)
+	s.packets <- c
+}
+
+func (s *testSink) Hold()   {}
+func (s *testSink) Rewind() {}
+
+// freeUDPAddr returns a local address nothing listens on.
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestUDPSource(t *testing.T) {
+	src := &UDPSource{Listen: freeUDPAddr(t)}
+	sink := &testSink{decoder: codemasters.NewDecoder(-1), packets: make(chan Packet, 64)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- src.Run(ctx, sink) }()
+
+	conn, err := net.Dial("udp", src.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b := dirtPacket(t, 1)
+	var p Packet
+	tick := time.NewTicker(10 * time.Millisecond) // until the source listens
+	defer tick.Stop()
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		conn.Write(b)
+		select {
+		case p = <-sink.packets:
+			break wait
+		case <-tick.C:
+		case <-timeout:
+			t.Fatal("no packet")
+		}
+	}
+	if p.Err != nil || p.Telemetry == nil || p.Format != codemasters.FormatDirtSeries {
+		t.Errorf("packet: format %q err %v", p.Format, p.Err)
+	}
+	if p.Origin.String() != conn.LocalAddr().String() {
+		t.Errorf("origin %s, want %s", p.Origin, conn.LocalAddr())
+	}
+	if string(p.Data) != string(b) {
+		t.Errorf("data: %d bytes, want %d", len(p.Data), len(b))
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
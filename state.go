@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// SessionState is the state of a driver, derived from session packets
+// and the monotonicity of the game clock.
+type SessionState string
+
+const (
+	StateIdle     SessionState = "idle"     // no packets
+	StateMenu     SessionState = "menu"     // packets, but the stage clock has not started
+	StateRunning  SessionState = "running"  // the stage clock advances
+	StatePaused   SessionState = "paused"   // the stage clock is frozen or the game sent a pause
+	StateFinished SessionState = "finished" // the stage is completed
+	StateReplay   SessionState = "replay"   // the stage clock jumped backwards or runs after the end of the stage
+)
+
+const (
+	pauseDelay   = 500 * time.Millisecond // frozen clock time before paused
+	rewindMargin = 1.0                    // seconds the clock may go back, e.g. packet jitter
+)
+
+// stateMachine derives the SessionState of a driver:
+//
+//   - session packets (EA Sports WRC) switch to running, paused and finished directly
+//   - the first packets start in menu, an advancing clock switches to running
+//   - a clock frozen for pauseDelay switches running to paused
+//   - the end of the stage (StageProgress 1) switches running to finished
+//   - a clock going backwards switches to replay, unless it restarts from zero
+//     in a game without session packets (stage restart)
+//   - a clock advancing after finished switches to replay in a game with session packets
+type stateMachine struct {
+	state    SessionState
+	sessions bool // the game sends session packets
+	started  bool // clock seen
+	lap      int
+	clock    float32
+	frozen   time.Time // first packet with a frozen clock, zero while it moves
+}
+
+// gameClock returns the stage clock of f and its lap.
+func gameClock(f *codemasters.Frame) (int, float32) {
+	if f.Has(codemasters.ChannelStage) {
+		return f.Lap, f.StageTime
+	}
+	return f.Lap, f.LapTime
+}
+
+func (m *stateMachine) set(s SessionState) bool {
+	if m.state == s {
+		return false
+	}
+	m.state = s
+	return true
+}
+
+// idle resets the machine when the driver stops sending.
+func (m *stateMachine) idle() bool {
+	m.started = false
+	return m.set(StateIdle)
+}
+
+// rewind restarts the clock, e.g. when a capture loops or seeks.
+func (m *stateMachine) rewind() {
+	m.started = false
+}
+
+// event applies a session packet.
+func (m *stateMachine) event(ev codemasters.Event) bool {
+	m.sessions = true
+	switch ev {
+	case codemasters.EventSessionStart:
+		m.started = false
+		m.frozen = time.Time{}
+		return m.set(StateRunning)
+	case codemasters.EventSessionPause:
+		return m.set(StatePaused)
+	case codemasters.EventSessionResume:
+		m.frozen = time.Time{}
+		return m.set(StateRunning)
+	case codemasters.EventSessionEnd:
+		return m.set(StateFinished)
+	}
+	return false
+}
+
+// freeze applies a packet repeating the clock of the previous one.
+func (m *stateMachine) freeze(t time.Time) bool {
+	if m.frozen.IsZero() {
+		m.frozen = t
+	}
+	if m.state == StateRunning && t.Sub(m.frozen) > pauseDelay {
+		return m.set(StatePaused)
+	}
+	return false
+}
+
+// frame applies a telemetry packet.
+func (m *stateMachine) frame(t time.Time, f *codemasters.Frame) bool {
+	lap, clock := gameClock(f)
+	if !m.started {
+		m.started, m.lap, m.clock, m.frozen = true, lap, clock, time.Time{}
+		if m.state == StateIdle || m.state == "" {
+			return m.set(StateMenu)
+		}
+		return false
+	}
+	prevLap, prev := m.lap, m.clock
+	m.lap, m.clock = lap, clock
+	switch {
+	case lap > prevLap || (lap == prevLap && clock > prev):
+		m.frozen = time.Time{}
+		switch m.state {
+		case StateFinished:
+			if m.sessions {
+				return m.set(StateReplay)
+			}
+		case StateReplay:
+		case StateRunning:
+			if f.Has(codemasters.ChannelStage) && f.StageLength > 0 && f.StageProgress >= 1 {
+				return m.set(StateFinished)
+			}
+		default:
+			return m.set(StateRunning)
+		}
+	case lap == prevLap && clock == prev:
+		return m.freeze(t)
+	case lap < prevLap || clock < prev-rewindMargin:
+		m.frozen = time.Time{}
+		if !m.sessions && clock < rewindMargin {
+			return m.set(StateRunning) // stage restart
+		}
+		return m.set(StateReplay)
+	}
+	return false
+}
+
+// StateEvent is sent as an SSE "state" event when the state of the followed driver changes.
+type StateEvent struct {
+	Source   string
+	Driver   string
+	State    SessionState
+	Previous SessionState
+}
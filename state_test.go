@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nobonobo/obs-codemasters-telemetry/codemasters"
+)
+
+// stateStep applies a packet or an event at seconds from the start.
+type stateStep struct {
+	at    float64
+	apply func(m *stateMachine, t time.Time) bool
+	want  SessionState
+}
+
+// lapClock is a packet of a game without the stage channel.
+func lapClock(at float64, clock float32, want SessionState) stateStep {
+	return stateStep{at, func(m *stateMachine, t time.Time) bool {
+		return m.frame(t, &codemasters.Frame{LapTime: clock})
+	}, want}
+}
+
+// stageClock is a packet of a 1000 m stage at progress.
+func stageClock(at float64, clock, progress float32, want SessionState) stateStep {
+	return stateStep{at, func(m *stateMachine, t time.Time) bool {
+		f := codemasters.Frame{Available: codemasters.ChannelStage, StageTime: clock, StageLength: 1000, StageProgress: progress}
+		return m.frame(t, &f)
+	}, want}
+}
+
+func sessionEvent(ev codemasters.Event, want SessionState) stateStep {
+	return stateStep{0, func(m *stateMachine, _ time.Time) bool { return m.event(ev) }, want}
+}
+
+func TestStateMachine(t *testing.T) {
+	idle := stateStep{0, func(m *stateMachine, _ time.Time) bool { return m.idle() }, StateIdle}
+	for _, c := range []struct {
+		name  string
+		steps []stateStep
+	}{
+		{"start", []stateStep{
+			lapClock(0, 5, StateMenu),
+			lapClock(0.1, 5, StateMenu), // menus repeat the clock
+			lapClock(0.2, 5.1, StateRunning),
+		}},
+		{"pause after a frozen clock", []stateStep{
+			lapClock(0, 1, StateMenu),
+			lapClock(0.1, 1.1, StateRunning),
+			lapClock(0.2, 1.1, StateRunning),
+			lapClock(0.6, 1.1, StateRunning), // frozen for 0.4 s
+			lapClock(0.8, 1.1, StatePaused),  // frozen for 0.6 s
+			lapClock(0.9, 1.2, StateRunning),
+			lapClock(1.0, 1.2, StateRunning), // the freeze restarts
+		}},
+		{"jitter", []stateStep{
+			lapClock(0, 1, StateMenu),
+			lapClock(0.1, 2, StateRunning),
+			lapClock(0.2, 1.5, StateRunning), // within rewindMargin
+		}},
+		{"restart from zero", []stateStep{
+			lapClock(0, 30, StateMenu),
+			lapClock(0.1, 30.1, StateRunning),
+			lapClock(0.2, 0.2, StateRunning),
+			lapClock(0.3, 0.3, StateRunning),
+		}},
+		{"jump back is a replay", []stateStep{
+			lapClock(0, 30, StateMenu),
+			lapClock(0.1, 30.1, StateRunning),
+			lapClock(0.2, 10, StateReplay),
+			lapClock(0.3, 10.1, StateReplay),
+		}},
+		{"jump back to zero with sessions is a replay", []stateStep{
+			sessionEvent(codemasters.EventSessionStart, StateRunning),
+			stageClock(0, 30, 0.5, StateRunning),
+			stageClock(0.1, 30.1, 0.5, StateRunning),
+			stageClock(0.2, 0.2, 0, StateReplay),
+		}},
+		{"finish", []stateStep{
+			stageClock(0, 100, 0.9, StateMenu),
+			stageClock(0.1, 100.1, 0.95, StateRunning),
+			stageClock(0.2, 100.2, 1, StateFinished),
+			stageClock(0.3, 100.3, 1, StateFinished), // driving on without sessions
+		}},
+		{"running after the end is a replay", []stateStep{
+			sessionEvent(codemasters.EventSessionStart, StateRunning),
+			stageClock(0, 100, 0.9, StateRunning),
+			sessionEvent(codemasters.EventSessionEnd, StateFinished),
+			stageClock(0.1, 100, 1, StateFinished),
+			stageClock(0.2, 100.1, 1, StateReplay),
+		}},
+		{"session events", []stateStep{
+			stageClock(0, 0, 0, StateMenu),
+			sessionEvent(codemasters.EventSessionStart, StateRunning),
+			sessionEvent(codemasters.EventSessionPause, StatePaused),
+			stageClock(0.1, 0, 0, StatePaused),
+			sessionEvent(codemasters.EventSessionResume, StateRunning),
+			sessionEvent(codemasters.EventSessionEnd, StateFinished),
+		}},
+		{"idle", []stateStep{
+			lapClock(0, 1, StateMenu),
+			lapClock(0.1, 1.1, StateRunning),
+			idle,
+			lapClock(10, 50, StateMenu),
+			lapClock(10.1, 50.1, StateRunning),
+		}},
+	} {
+		var m stateMachine
+		m.set(StateIdle)
+		t0 := time.Unix(1000, 0)
+		for i, s := range c.steps {
+			prev := m.state
+			changed := s.apply(&m, t0.Add(time.Duration(s.at*float64(time.Second))))
+			if m.state != s.want || changed != (prev != s.want) {
+				t.Errorf("%s %d: state %s changed %v, want %s", c.name, i, m.state, changed, s.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type restartPolicy int
+
+const (
+	restartNever     restartPolicy = iota // run once
+	restartOnFailure                      // restart when the component returns an error
+	restartAlways                         // restart whenever the component returns
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second // also the run time after which the backoff is reset
+)
+
+// supervisor runs the components of the server until their context is done.
+type supervisor struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a goroutine, restarted by policy with exponential backoff.
+func (s *supervisor) Go(ctx context.Context, name string, policy restartPolicy, fn func(context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := minBackoff
+		for {
+			start := time.Now()
+			err := fn(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("%s: %v", name, err)
+			}
+			if policy == restartNever || (policy == restartOnFailure && err == nil) {
+				return
+			}
+			if time.Since(start) > maxBackoff {
+				backoff = minBackoff
+			}
+			log.Printf("%s: restart in %v", name, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+// Wait waits for all components to return.
+func (s *supervisor) Wait() {
+	s.wg.Wait()
+}